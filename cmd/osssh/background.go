@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modzilla99/osssh/internal/control"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+const backgroundStartTimeout = 2 * time.Minute
+
+// startBackground starts osssh again with the same arguments detached from
+// the terminal and waits until its tunnel is up.
+func startBackground() int {
+	dir, err := control.StateDir()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	id := control.NewID()
+
+	fmt.Print("Starting tunnel in the background...")
+	err = detach(os.Args[1:], []string{control.DaemonEnv + "=" + id}, control.LogPath(dir, id), control.SocketPath(dir, id))
+	if err != nil {
+		fmt.Println("Error")
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println("Done")
+	fmt.Printf("Tunnel %s running, stop it with: osssh stop %s\n", id, id)
+	return 0
+}
+
+// detach starts osssh with arguments in a new session, writing its output to
+// logPath, and waits until it has created the socket sock. If it exits before
+// that, its output is returned as the error and the log is removed.
+func detach(arguments, env []string, logPath, sock string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, arguments...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	timeout := time.After(backgroundStartTimeout)
+	for {
+		select {
+		case <-exited:
+			log, _ := os.ReadFile(logPath)
+			os.Remove(logPath)
+			return fmt.Errorf("%s", strings.TrimSpace(string(log)))
+		case <-timeout:
+			return fmt.Errorf("not up within %s, see %s", backgroundStartTimeout, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+	}
+}
+
+// serveControl registers the tunnel running in the background, a stop
+// request cancels it. info returns nil while a lazy tunnel has not been up
+// yet. The returned function unregisters the tunnel, osssh stop waits for
+// that, so it is called once the tunnel has been torn down.
+func serveControl(stop context.CancelFunc, id string, info func() *openstack.Info, args generic.Args, stats *ssh.Stats) (func(), error) {
+	forwards := make([]string, 0, len(args.Forwards)+len(args.Reverse))
+	for _, fw := range args.Forwards {
+		forwards = append(forwards, fmt.Sprintf("%s->%d/%s", fw.LocalAddress(), fw.RemotePort, fw.Type))
+	}
+	for _, r := range args.Reverse {
+		forwards = append(forwards, fmt.Sprintf("%s:%d<-%s:%d", r.BindAddress, r.RemotePort, r.LocalHost, r.LocalPort))
+	}
+
+	srv, err := control.Listen(id)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	go srv.Serve(func() control.Tunnel {
+		t := control.Tunnel{
+			ID:         id,
+			PID:        os.Getpid(),
+			Server:     args.Server,
+			Hypervisor: "-",
+			Forwards:   forwards,
+			Started:    started,
+			BytesIn:    stats.In.Load(),
+			BytesOut:   stats.Out.Load(),
+		}
+		if i := info(); i != nil {
+			t.Server, t.ServerID, t.Hypervisor = i.ServerName, i.ServerID, i.HypervisorHostname
+		}
+		return t
+	}, stop)
+	return func() { srv.Close() }, nil
+}
+
+// psCommand lists the tunnels running in the background.
+func psCommand(ctx context.Context, arguments []string) int {
+	tunnels, err := control.List()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSERVER\tHYPERVISOR\tFORWARDS\tUPTIME\tIN\tOUT")
+	for _, t := range tunnels {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.ID, t.Server, t.Hypervisor, strings.Join(t.Forwards, ","),
+			time.Since(t.Started).Truncate(time.Second), ssh.FormatBytes(t.BytesIn), ssh.FormatBytes(t.BytesOut),
+		)
+	}
+	w.Flush()
+	return 0
+}
+
+// stopCommand stops tunnels running in the background.
+func stopCommand(ctx context.Context, arguments []string) int {
+	if len(arguments) != 1 {
+		fmt.Println("Usage: osssh stop <id|all>")
+		return 1
+	}
+
+	ids := []string{arguments[0]}
+	if arguments[0] == "all" {
+		tunnels, err := control.List()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		ids = ids[:0]
+		for _, t := range tunnels {
+			ids = append(ids, t.ID)
+		}
+	}
+
+	code := 0
+	for _, id := range ids {
+		fmt.Printf("Stopping tunnel %s...", id)
+		if _, err := control.Stop(id, 15*time.Second); err != nil {
+			fmt.Println("Error")
+			fmt.Println(err)
+			code = 1
+			continue
+		}
+		fmt.Println("Done")
+	}
+	return code
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+	"github.com/pkg/sftp"
+)
+
+// splitRemotePath splits server:path into its parts. Paths containing a
+// slash before the colon are local.
+func splitRemotePath(s string) (server, path string, ok bool) {
+	server, path, ok = strings.Cut(s, ":")
+	if !ok || server == "" || strings.Contains(server, "/") {
+		return "", "", false
+	}
+	if path == "" {
+		path = "."
+	}
+	return server, path, true
+}
+
+// cpCommand copies files from and to a server over SFTP.
+func cpCommand(ctx context.Context, arguments []string) int {
+	var (
+		args      generic.Args
+		vm        vmFlags
+		recursive bool
+	)
+	fs := utils.NewFlagSet("cp", &args)
+	utils.MasterFlag(fs, &args)
+	utils.ScopeFlags(fs, &args)
+	utils.SearchFlags(fs, &args)
+	vm.register(fs)
+	fs.BoolVar(&recursive, "R", false, "copy directories recursively")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh cp [flags] server:path localpath")
+		fmt.Println("       osssh cp [flags] localpath server:path")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+	if args.Username == "" {
+		fmt.Println("Cannot get username from environment, please specify username with -u")
+		return 1
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+	srcServer, srcPath, download := splitRemotePath(src)
+	dstServer, dstPath, upload := splitRemotePath(dst)
+	switch {
+	case download && upload:
+		fmt.Println("Copying between two servers is not supported")
+		return 1
+	case download:
+		args.Server = srcServer
+	case upload:
+		args.Server = dstServer
+	default:
+		fmt.Println("Either source or destination has to be in the form server:path")
+		return 1
+	}
+
+	return withTunnel(ctx, args, func(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel) (int, error) {
+		c, err := dialVM(ctx, osc, t, vm)
+		if err != nil {
+			return 1, err
+		}
+		defer c.Close()
+
+		sc, err := sftp.NewClient(c)
+		if err != nil {
+			return 1, fmt.Errorf("unable to start sftp: %w", err)
+		}
+		defer sc.Close()
+
+		if download {
+			err = ssh.Download(sc, srcPath, dst, recursive)
+		} else {
+			err = ssh.Upload(sc, src, dstPath, recursive)
+		}
+		if err != nil {
+			return 1, err
+		}
+		return 0, nil
+	})
+}
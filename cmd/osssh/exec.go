@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// execCommand runs a local command for the lifetime of a tunnel to the
+// server. {host} and {port} in its arguments are replaced by the local end
+// of the tunnel, which is also passed in OSSSH_HOST and OSSSH_PORT.
+func execCommand(ctx context.Context, arguments []string) int {
+	var args generic.Args
+	fs := utils.NewFlagSet("exec", &args)
+	utils.MasterFlag(fs, &args)
+	utils.ScopeFlags(fs, &args)
+	utils.SearchFlags(fs, &args)
+	fs.IntVar(&args.RemotePort, "r", 22, "Remote port to forward traffic to")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh exec [flags] server [flags] -- command [args]")
+		fs.PrintDefaults()
+	}
+	extra := utils.ParseSubcommand(fs, &args, arguments)
+	if len(extra) == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	return withTunnel(ctx, args, func(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel) (int, error) {
+		return withLocalForward(ctx, t, args.RemotePort, func(port int) (int, error) {
+			r := strings.NewReplacer("{host}", "127.0.0.1", "{port}", strconv.Itoa(port))
+			cmdArgs := make([]string, 0, len(extra))
+			for _, a := range extra {
+				cmdArgs = append(cmdArgs, r.Replace(a))
+			}
+
+			cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+			cmd.Env = append(os.Environ(),
+				"OSSSH_HOST=127.0.0.1",
+				"OSSSH_PORT="+strconv.Itoa(port),
+			)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return runCommand(cmd)
+		})
+	})
+}
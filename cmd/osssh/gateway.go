@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// gateway proxies HTTP requests for <server>.<port>.<base> to the port of
+// the server. Every server gets one lazy tunnel shared by all its ports.
+type gateway struct {
+	ctx     context.Context
+	osc     *openstack.OpenStackClient
+	tunnels *lazyTunnels
+	proxy   *httputil.ReverseProxy
+	// listen is the address the gateway listens on, bases are checked
+	// against it
+	listen string
+}
+
+// gatewayTarget is the server and port a request is for, base is the rest
+// of the requested host, e.g. localhost:8080.
+type gatewayTarget struct {
+	server string
+	port   int
+	base   string
+}
+
+func parseGatewayHost(host string) (gatewayTarget, bool) {
+	labels := strings.SplitN(host, ".", 3)
+	if len(labels) != 3 || labels[0] == "" {
+		return gatewayTarget{}, false
+	}
+	port, err := strconv.Atoi(labels[1])
+	if err != nil || port < 1 || port > 65535 {
+		return gatewayTarget{}, false
+	}
+	return gatewayTarget{server: labels[0], port: port, base: labels[2]}, true
+}
+
+// baseAllowed reports whether requests for base are served by a gateway
+// listening on listen: base has to be localhost or the listen host, with no
+// port or the listen port. Other names could be a DNS rebinding attack of a
+// web page pointing its own domain at the gateway.
+func baseAllowed(base, listen string) bool {
+	listenHost, listenPort, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	host, port := base, ""
+	if h, p, err := net.SplitHostPort(base); err == nil {
+		host, port = h, p
+	}
+	if port != "" && port != listenPort {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(listenHost)
+	if listenHost == "" || ip != nil && ip.IsUnspecified() {
+		return false
+	}
+	return strings.EqualFold(strings.Trim(host, "[]"), listenHost)
+}
+
+func (t gatewayTarget) host() string {
+	return fmt.Sprintf("%s.%d.%s", t.server, t.port, t.base)
+}
+
+// gatewayCommand serves the web services of VMs on
+// http://<server>.<port>.localhost:<listen port>/.
+func gatewayCommand(ctx context.Context, arguments []string) int {
+	var (
+		args        generic.Args
+		listen      string
+		idleTimeout time.Duration
+	)
+	fs := utils.NewFlagSet("gateway", &args)
+	utils.ScopeFlags(fs, &args)
+	fs.StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 5*time.Minute, "tear tunnels down after no connection has been open for this long")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh gateway [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	osc, err := createClient(ctx, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// tunnels are brought up concurrently, requests are logged instead
+	progress.Output = io.Discard
+
+	// the host as given, it may be a name, with the port actually bound
+	listenHost, _, _ := net.SplitHostPort(listen)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	gw := &gateway{
+		ctx:     ctx,
+		osc:     osc,
+		tunnels: newLazyTunnels(ctx, args.Username, idleTimeout),
+		listen:  net.JoinHostPort(listenHost, port),
+	}
+	gw.proxy = gw.newProxy()
+	defer gw.tunnels.Close()
+
+	fmt.Printf("Serving http://<server>.<port>.localhost:%s/ on %s\n", port, listener.Addr())
+
+	srv := &http.Server{Handler: gw}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// gatewayRequest is passed to the proxy in the context of the request.
+type gatewayRequest struct {
+	target gatewayTarget
+	info   *openstack.Info
+	lazy   *tunnel.Lazy
+}
+
+type gatewayRequestKey struct{}
+
+func requestOf(ctx context.Context) *gatewayRequest {
+	return ctx.Value(gatewayRequestKey{}).(*gatewayRequest)
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := parseGatewayHost(r.Host)
+	if !ok || !baseAllowed(target.base, gw.listen) {
+		http.Error(w, "request http://<server>.<port>.localhost/ to reach a port of a server", http.StatusNotFound)
+		return
+	}
+
+	info, lazy, err := gw.tunnels.get(target.server, func() (*openstack.Info, error) {
+		return openstack.GetInfo(gw.ctx, gw.osc, target.server)
+	})
+	if err != nil {
+		fmt.Printf("%s %s %s: %s\n", time.Now().Format(time.TimeOnly), r.Method, target.host(), err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	req := &gatewayRequest{target: target, info: info, lazy: lazy}
+
+	fmt.Printf("%s %s %s%s\n", time.Now().Format(time.TimeOnly), r.Method, target.host(), r.URL.RequestURI())
+	gw.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayRequestKey{}, req)))
+}
+
+func (gw *gateway) newProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			req := requestOf(pr.In.Context())
+			port := strconv.Itoa(req.target.port)
+			pr.Out.URL.Scheme = "http"
+			// connections are pooled per server and port, see DialContext
+			pr.Out.URL.Host = net.JoinHostPort(req.info.ServerID, port)
+			pr.Out.Host = net.JoinHostPort(req.info.IPAddress, port)
+			pr.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				req := requestOf(ctx)
+				return req.lazy.Dial("tcp", req.target.port)
+			},
+			// idle connections keep the tunnel up
+			IdleConnTimeout: 30 * time.Second,
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			req := requestOf(resp.Request.Context())
+			rewriteLocation(resp, req.info, req.target)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			req := requestOf(r.Context())
+			fmt.Printf("%s %s %s: %s\n", time.Now().Format(time.TimeOnly), r.Method, req.target.host(), err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+}
+
+// rewriteLocation points redirects to the internal address of the server
+// back to the gateway. Redirects to https are left alone, only http backends
+// are proxied.
+func rewriteLocation(resp *http.Response, info *openstack.Info, target gatewayTarget) {
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Host == "" || loc.Hostname() != info.IPAddress {
+		return
+	}
+	if loc.Scheme != "" && loc.Scheme != "http" {
+		return
+	}
+
+	port := target.port
+	switch {
+	case loc.Port() != "":
+		port, _ = strconv.Atoi(loc.Port())
+	case loc.Scheme == "http":
+		port = 80
+	}
+
+	loc.Scheme = "http"
+	loc.Host = gatewayTarget{server: target.server, port: port, base: target.base}.host()
+	resp.Header.Set("Location", loc.String())
+}
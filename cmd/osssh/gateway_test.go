@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+)
+
+func TestParseGatewayHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		want   gatewayTarget
+		wantOk bool
+	}{
+		{host: "web01.80.localhost:8080", want: gatewayTarget{server: "web01", port: 80, base: "localhost:8080"}, wantOk: true},
+		{host: "web01.3000.localhost", want: gatewayTarget{server: "web01", port: 3000, base: "localhost"}, wantOk: true},
+		{host: "web01.80.attacker.example", want: gatewayTarget{server: "web01", port: 80, base: "attacker.example"}, wantOk: true},
+		{host: "web01.http.localhost", wantOk: false},
+		{host: "web01.0.localhost", wantOk: false},
+		{host: "web01.65536.localhost", wantOk: false},
+		{host: ".80.localhost", wantOk: false},
+		{host: "localhost:8080", wantOk: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseGatewayHost(tt.host)
+		if ok != tt.wantOk || ok && got != tt.want {
+			t.Errorf("parseGatewayHost(%q) = %+v, %t, want %+v, %t", tt.host, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestBaseAllowed(t *testing.T) {
+	tests := []struct {
+		base   string
+		listen string
+		want   bool
+	}{
+		{base: "localhost:8080", listen: "127.0.0.1:8080", want: true},
+		{base: "LOCALHOST:8080", listen: "127.0.0.1:8080", want: true},
+		{base: "localhost", listen: "127.0.0.1:80", want: true},
+		{base: "localhost:9090", listen: "127.0.0.1:8080", want: false},
+		{base: "127.0.0.1:8080", listen: "127.0.0.1:8080", want: true},
+		{base: "gw.internal:8080", listen: "gw.internal:8080", want: true},
+		{base: "[::1]:8080", listen: "[::1]:8080", want: true},
+		{base: "attacker.example:8080", listen: "127.0.0.1:8080", want: false},
+		{base: "attacker.example", listen: "127.0.0.1:8080", want: false},
+		{base: "localhost.attacker.example:8080", listen: "127.0.0.1:8080", want: false},
+		{base: "0.0.0.0:8080", listen: "0.0.0.0:8080", want: false},
+		{base: ":8080", listen: ":8080", want: false},
+		{base: "localhost:8080", listen: ":8080", want: true},
+	}
+	for _, tt := range tests {
+		if got := baseAllowed(tt.base, tt.listen); got != tt.want {
+			t.Errorf("baseAllowed(%q, %q) = %t, want %t", tt.base, tt.listen, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	info := &openstack.Info{IPAddress: "10.0.0.12"}
+	target := gatewayTarget{server: "web01", port: 3000, base: "localhost:8080"}
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{location: "http://10.0.0.12:3000/login", want: "http://web01.3000.localhost:8080/login"},
+		{location: "http://10.0.0.12/", want: "http://web01.80.localhost:8080/"},
+		{location: "//10.0.0.12/login", want: "http://web01.3000.localhost:8080/login"},
+		{location: "https://10.0.0.12/login", want: "https://10.0.0.12/login"},
+		{location: "https://10.0.0.12:8443/", want: "https://10.0.0.12:8443/"},
+		{location: "http://sso.example.com/auth", want: "http://sso.example.com/auth"},
+		{location: "/login", want: "/login"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Location": {tt.location}}}
+		rewriteLocation(resp, info, target)
+		if got := resp.Header.Get("Location"); got != tt.want {
+			t.Errorf("rewriteLocation(%q) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/ssh"
+)
+
+// verifyHostKeys fetches the host key fingerprints cloud-init printed to the
+// console of the server and writes the matching host keys to knownHosts. If
+// the console only contains fingerprints, the host key is fetched from the
+// SSH server reached by dial and checked against them.
+func verifyHostKeys(ctx context.Context, osc *openstack.OpenStackClient, info *openstack.Info, dial func() (net.Conn, error), knownHosts string) error {
+	progress.Print("Verifying host keys from console output...")
+	console, err := openstack.GetConsoleOutput(ctx, osc, info.ServerID)
+	if err != nil {
+		progress.Println("Error")
+		return fmt.Errorf("unable to get console output: %w", err)
+	}
+
+	hk, err := ssh.ParseConsoleHostKeys(console)
+	if err != nil {
+		progress.Println("Error")
+		return err
+	}
+
+	keys := hk.Verified()
+	if len(keys) == 0 {
+		conn, err := dial()
+		if err != nil {
+			progress.Println("Error")
+			return err
+		}
+		key, err := hk.ScanHostKey(conn)
+		if err != nil {
+			progress.Println("Error")
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	err = ssh.WriteKnownHosts(knownHosts, ssh.HostKeyAlias(info.ServerID), keys)
+	if err != nil {
+		progress.Println("Error")
+		return err
+	}
+	progress.Println("Done")
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modzilla99/osssh/internal/dns"
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// vmHost is a VM reachable on its own loopback address.
+type vmHost struct {
+	info  *openstack.Info
+	addr  netip.Addr
+	names []string
+}
+
+// hostsCommand gives every VM its own loopback address, resolvable by name
+// through a local DNS server or an /etc/hosts fragment. The ports of a VM are
+// forwarded on its address, its tunnel is brought up on the first connection.
+func hostsCommand(ctx context.Context, arguments []string) int {
+	var (
+		args        generic.Args
+		filter      openstack.ServerFilter
+		servers     stringsFlag
+		ports       string
+		subnet      string
+		dnsAddress  string
+		hostsFile   string
+		domain      string
+		idleTimeout time.Duration
+	)
+	fs := utils.NewFlagSet("hosts", &args)
+	utils.ScopeFlags(fs, &args)
+	fs.Var((*stringsFlag)(&filter.Tags), "tag", "only servers with this Nova tag, can be repeated")
+	fs.StringVar(&filter.ServerGroup, "server-group", "", "only members of this server group (name or id)")
+	fs.Var(&servers, "server", "only this server (name or id), can be repeated")
+	fs.StringVar(&ports, "ports", "22", "comma separated ports forwarded on the address of every server")
+	fs.StringVar(&subnet, "subnet", "127.77.0.0/16", "loopback subnet the addresses of the servers are taken from")
+	fs.StringVar(&dnsAddress, "dns", "127.0.0.1:5300", "address the DNS server for the names listens on, empty to disable it")
+	fs.StringVar(&hostsFile, "hosts-file", "", "write an /etc/hosts fragment with the names to this file, - for stdout")
+	fs.StringVar(&domain, "domain", "osssh", "domain the names are created in")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 5*time.Minute, "tear tunnels down after no connection has been open for this long")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh hosts [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	portList, err := parsePorts(ports)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if !prefix.Addr().Is4() || !netip.MustParsePrefix("127.0.0.0/8").Contains(prefix.Addr()) || prefix.Bits() < 8 {
+		fmt.Printf("%s is not a loopback subnet\n", subnet)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	osc, err := createClient(ctx, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	project, err := osc.ProjectName()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	infos, err := openstack.FindServers(ctx, osc, filter)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if len(servers) > 0 {
+		infos = slices.DeleteFunc(infos, func(i *openstack.Info) bool {
+			return !slices.Contains(servers, i.ServerName) && !slices.Contains(servers, i.ServerID)
+		})
+	}
+	if len(infos) == 0 {
+		fmt.Println("No matching servers found")
+		return 1
+	}
+
+	hosts, err := assignAddresses(infos, prefix)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if missing := missingAliases(hosts); len(missing) > 0 {
+		fmt.Println("The loopback interface has no alias for these addresses, add them with:")
+		for _, addr := range missing {
+			fmt.Printf("  sudo ifconfig lo0 alias %s up\n", addr)
+		}
+		return 1
+	}
+	names := map[string]netip.Addr{}
+	for _, h := range hosts {
+		suffix := "." + dnsLabel(project) + "." + domain
+		for _, n := range []string{dnsLabel(h.info.ServerName), h.info.ServerID} {
+			if n == "" {
+				continue
+			}
+			name := n + suffix
+			if _, ok := names[name]; ok {
+				fmt.Printf("Warning: %s is used by more than one server, use its id instead\n", name)
+				continue
+			}
+			names[name] = h.addr
+			h.names = append(h.names, name)
+		}
+	}
+
+	if hostsFile != "" {
+		if err := writeHostsFragment(hostsFile, project, hosts); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	// tunnels are brought up concurrently, their status is reported instead
+	progress.Output = io.Discard
+
+	var wg sync.WaitGroup
+	if dnsAddress != "" {
+		conn, err := net.ListenPacket("udp", dnsAddress)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		wg.Go(func() {
+			err := dns.Serve(ctx, conn, func(name string) (netip.Addr, bool) {
+				addr, ok := names[name]
+				return addr, ok
+			})
+			if err != nil {
+				fmt.Println(err)
+				cancel()
+			}
+		})
+	}
+
+	hypervisors := newHypervisorPool(args.Username)
+	defer hypervisors.Close()
+
+	var started sync.WaitGroup
+	started.Add(len(hosts))
+	for _, h := range hosts {
+		g := &tunnelGroup{
+			server:  h.info.ServerID,
+			name:    h.info.ServerName,
+			network: h.info.NetworkID,
+			status:  "idle",
+		}
+		for _, p := range portList {
+			g.forwards = append(g.forwards, generic.Forward{
+				Type:        "tcp",
+				BindAddress: h.addr.String(),
+				LocalPort:   p,
+				RemotePort:  p,
+			})
+		}
+		wg.Go(func() {
+			runLazyTunnelGroup(ctx, osc, hypervisors, g, idleTimeout, started.Done)
+		})
+	}
+	started.Wait()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tNAME\tPORTS")
+	for _, h := range hosts {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", h.addr, h.names[0], ports)
+	}
+	w.Flush()
+	if dnsAddress != "" {
+		fmt.Printf("Resolving *.%s.%s on %s\n", dnsLabel(project), domain, dnsAddress)
+	}
+
+	wg.Wait()
+	return 0
+}
+
+// missingAliases returns the addresses of hosts that can't be bound, like
+// on macOS, where only 127.0.0.1 is configured on the loopback interface.
+func missingAliases(hosts []*vmHost) []netip.Addr {
+	var missing []netip.Addr
+	for _, h := range hosts {
+		listener, err := net.Listen("tcp", net.JoinHostPort(h.addr.String(), "0"))
+		if errors.Is(err, syscall.EADDRNOTAVAIL) {
+			missing = append(missing, h.addr)
+		} else if err == nil {
+			listener.Close()
+		}
+	}
+	return missing
+}
+
+// assignAddresses gives every server an address in prefix. The addresses are
+// derived from the server ids, so they mostly stay the same while servers
+// come and go.
+func assignAddresses(infos []*openstack.Info, prefix netip.Prefix) ([]*vmHost, error) {
+	// skip the network and broadcast address
+	size := uint32(1)<<(32-prefix.Bits()) - 2
+	if uint32(len(infos)) >= size {
+		return nil, fmt.Errorf("subnet %s is too small for %d servers", prefix, len(infos))
+	}
+
+	infos = slices.Clone(infos)
+	slices.SortFunc(infos, func(a, b *openstack.Info) int {
+		return strings.Compare(a.ServerID, b.ServerID)
+	})
+
+	base := prefix.Masked().Addr().As4()
+	first := binary.BigEndian.Uint32(base[:])
+	used := map[uint32]bool{}
+	hosts := make([]*vmHost, 0, len(infos))
+	for _, info := range infos {
+		h := fnv.New32a()
+		h.Write([]byte(info.ServerID))
+		offset := h.Sum32() % size
+		for used[offset] || first+offset+1 == 0x7f000001 {
+			offset = (offset + 1) % size
+		}
+		used[offset] = true
+
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], first+offset+1)
+		hosts = append(hosts, &vmHost{info: info, addr: netip.AddrFrom4(ip)})
+	}
+
+	slices.SortFunc(hosts, func(a, b *vmHost) int {
+		return strings.Compare(a.info.ServerName, b.info.ServerName)
+	})
+	return hosts, nil
+}
+
+// dnsLabel turns s into a valid DNS label.
+func dnsLabel(s string) string {
+	label := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, s)
+	label = strings.Trim(label, "-")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+func writeHostsFragment(file, project string, hosts []*vmHost) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# osssh hosts of project %s\n", project)
+	for _, h := range hosts {
+		fmt.Fprintf(&b, "%s\t%s\n", h.addr, strings.Join(h.names, " "))
+	}
+
+	if file == "-" {
+		fmt.Print(b.String())
+		return nil
+	}
+	return os.WriteFile(file, []byte(b.String()), 0o644)
+}
+
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, p := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
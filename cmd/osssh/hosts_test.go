@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+)
+
+func testServers(n int) []*openstack.Info {
+	infos := make([]*openstack.Info, n)
+	for i := range infos {
+		infos[i] = &openstack.Info{
+			ServerID:   fmt.Sprintf("6c1b9b2e-0000-4000-8000-%012d", i),
+			ServerName: fmt.Sprintf("vm%02d", i),
+		}
+	}
+	return infos
+}
+
+func TestAssignAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		servers int
+		wantErr bool
+	}{
+		{name: "default subnet", subnet: "127.77.0.0/16", servers: 50},
+		// every address of the subnet is used, colliding hashes are probed
+		{name: "full subnet", subnet: "127.77.0.0/28", servers: 13},
+		{name: "too small", subnet: "127.77.0.0/28", servers: 14, wantErr: true},
+		// 127.0.0.1 is skipped, leaving 5 of the 6 host addresses
+		{name: "skips localhost", subnet: "127.0.0.0/29", servers: 5},
+		{name: "unmasked prefix", subnet: "127.77.1.9/24", servers: 20},
+		{name: "no servers", subnet: "127.77.0.0/16", servers: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := netip.MustParsePrefix(tt.subnet)
+			hosts, err := assignAddresses(testServers(tt.servers), prefix)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("assignAddresses succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("assignAddresses: %s", err)
+			}
+			if len(hosts) != tt.servers {
+				t.Fatalf("got %d hosts, want %d", len(hosts), tt.servers)
+			}
+
+			masked := prefix.Masked()
+			network := masked.Addr()
+			broadcast := network
+			for i := 0; i < 1<<(32-prefix.Bits())-1; i++ {
+				broadcast = broadcast.Next()
+			}
+			seen := map[netip.Addr]string{}
+			for i, h := range hosts {
+				if !masked.Contains(h.addr) {
+					t.Errorf("%s got %s outside of %s", h.info.ServerName, h.addr, masked)
+				}
+				if h.addr == network || h.addr == broadcast {
+					t.Errorf("%s got the network or broadcast address %s", h.info.ServerName, h.addr)
+				}
+				if h.addr == netip.MustParseAddr("127.0.0.1") {
+					t.Errorf("%s got 127.0.0.1", h.info.ServerName)
+				}
+				if other, ok := seen[h.addr]; ok {
+					t.Errorf("%s and %s both got %s", h.info.ServerName, other, h.addr)
+				}
+				seen[h.addr] = h.info.ServerName
+				if i > 0 && hosts[i-1].info.ServerName > h.info.ServerName {
+					t.Errorf("hosts not sorted by name: %s before %s", hosts[i-1].info.ServerName, h.info.ServerName)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignAddressesStable(t *testing.T) {
+	prefix := netip.MustParsePrefix("127.77.0.0/16")
+	servers := testServers(20)
+
+	addrs := func(infos []*openstack.Info) map[string]netip.Addr {
+		hosts, err := assignAddresses(infos, prefix)
+		if err != nil {
+			t.Fatalf("assignAddresses: %s", err)
+		}
+		m := map[string]netip.Addr{}
+		for _, h := range hosts {
+			m[h.info.ServerID] = h.addr
+		}
+		return m
+	}
+
+	before := addrs(servers)
+	// the order the servers are listed in doesn't matter
+	reversed := make([]*openstack.Info, len(servers))
+	for i, s := range servers {
+		reversed[len(servers)-1-i] = s
+	}
+	for id, addr := range addrs(reversed) {
+		if before[id] != addr {
+			t.Errorf("%s moved from %s to %s when listed in reverse", id, before[id], addr)
+		}
+	}
+	// servers keep their address while another one is removed
+	for id, addr := range addrs(servers[1:]) {
+		if before[id] != addr {
+			t.Errorf("%s moved from %s to %s after removing a server", id, before[id], addr)
+		}
+	}
+}
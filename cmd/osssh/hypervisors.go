@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/modzilla99/osssh/internal/netnsproxy"
+	"github.com/modzilla99/osssh/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// hypervisorPool shares one connection per hypervisor between all tunnels to
+// servers running on it. Connections are established on first use.
+type hypervisorPool struct {
+	username string
+
+	mu    sync.Mutex
+	conns map[string]*hypervisorConn
+}
+
+type hypervisorConn struct {
+	mu     sync.Mutex
+	client *gossh.Client
+	// closed is closed once the connection is gone, all tunnels using it
+	// select on it instead of waiting for the client themselves
+	closed chan struct{}
+}
+
+func newHypervisorPool(username string) *hypervisorPool {
+	return &hypervisorPool{
+		username: username,
+		conns:    map[string]*hypervisorConn{},
+	}
+}
+
+// get returns the connection to the hypervisor with the netns-proxy set up
+// and a channel closed once the connection is gone.
+func (p *hypervisorPool) get(hostname string) (*gossh.Client, <-chan struct{}, error) {
+	p.mu.Lock()
+	h, ok := p.conns[hostname]
+	if !ok {
+		h = &hypervisorConn{}
+		p.conns[hostname] = h
+	}
+	p.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.client != nil {
+		return h.client, h.closed, nil
+	}
+
+	c, err := ssh.NewClient(hostname, p.username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := netnsproxy.Setup(c); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	closed := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(closed)
+	}()
+	h.client, h.closed = c, closed
+	return c, closed, nil
+}
+
+// drop closes a broken connection, the next get reconnects.
+func (p *hypervisorPool) drop(hostname string, c *gossh.Client) {
+	p.mu.Lock()
+	h, ok := p.conns[hostname]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.client == c {
+		h.client.Close()
+		h.client, h.closed = nil, nil
+	}
+}
+
+// Close closes all connections.
+func (p *hypervisorPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.conns {
+		h.mu.Lock()
+		if h.client != nil {
+			h.client.Close()
+			h.client, h.closed = nil, nil
+		}
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/modzilla99/osssh/internal/control"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+	"golang.org/x/sync/errgroup"
+)
+
+// runLazy binds the local listeners of the forwards right away, the server
+// is looked up and the tunnel brought up on the first connection.
+func runLazy(ctx context.Context, args generic.Args) error {
+	if len(args.Reverse) > 0 {
+		return errors.New("reverse forwards cannot be lazy")
+	}
+	for _, fw := range args.Forwards {
+		if fw.Type != "tcp" {
+			return errors.New("lazy tunnels only support tcp forwards")
+		}
+	}
+
+	var cancel context.CancelFunc
+	ctx, cancel = signal.NotifyContext(ctx, os.Interrupt, os.Kill, syscall.SIGTERM)
+	defer cancel()
+
+	lookup := func(ctx context.Context) (*openstack.Info, error) {
+		_, info, err := getInfo(ctx, args)
+		return info, err
+	}
+	// the session is kept for later lookups unless every cloud is searched
+	if !args.AllClouds && !args.AllRegions {
+		osc, err := createClient(ctx, args)
+		if err != nil {
+			return err
+		}
+		lookup = func(ctx context.Context) (*openstack.Info, error) {
+			_, info, err := findServer(ctx, osc, args)
+			return info, err
+		}
+	}
+
+	var current atomic.Pointer[openstack.Info]
+	lazy := tunnel.NewLazy(ctx, func(ctx context.Context) (*tunnel.Tunnel, func(error), error) {
+		info, err := lookup(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := openTunnel(ctx, info, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		current.Store(info)
+		fmt.Printf("Tunnel to %s on %s up\n", info.ServerName, location(info))
+
+		return t, func(err error) {
+			t.Close()
+			if err != nil {
+				fmt.Printf("Tunnel to %s failed: %s\n", info.ServerName, err)
+				return
+			}
+			fmt.Printf("Tunnel to %s closed after being idle for %s\n", info.ServerName, args.IdleTimeout)
+		}, nil
+	}, args.IdleTimeout)
+	defer lazy.Close()
+
+	group, ctx := errgroup.WithContext(ctx)
+	stats := &ssh.Stats{}
+	for _, fw := range args.Forwards {
+		listener, err := net.Listen("tcp", fw.LocalAddress())
+		if err != nil {
+			return err
+		}
+		group.Go(func() error {
+			return ssh.ServeDial(ctx, listener, func() (net.Conn, error) {
+				return lazy.Dial(fw.Type, fw.RemotePort)
+			}, stats)
+		})
+
+		fmt.Printf("Forwarding %s/%s to port %d of %s on first connection\n",
+			fw.LocalAddress(), fw.Type, fw.RemotePort, args.Server)
+	}
+
+	if id := os.Getenv(control.DaemonEnv); id != "" {
+		unregister, err := serveControl(cancel, id, current.Load, args, stats)
+		if err != nil {
+			return err
+		}
+		defer unregister()
+	}
+
+	err := group.Wait()
+	// the tunnel has to be torn down before it is unregistered
+	lazy.Close()
+	return err
+}
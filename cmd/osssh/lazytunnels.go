@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/tunnel"
+)
+
+// lazyTunnels keeps one lazy tunnel per server, sharing the connections to
+// the hypervisors. Servers are looked up on first use and again after their
+// tunnel failed, they may have moved to another hypervisor.
+type lazyTunnels struct {
+	ctx         context.Context
+	hypervisors *hypervisorPool
+	idleTimeout time.Duration
+
+	mu  sync.Mutex
+	vms map[string]*lazyVM
+}
+
+type lazyVM struct {
+	mu   sync.Mutex
+	info *openstack.Info
+	lazy *tunnel.Lazy
+}
+
+func newLazyTunnels(ctx context.Context, username string, idleTimeout time.Duration) *lazyTunnels {
+	return &lazyTunnels{
+		ctx:         ctx,
+		hypervisors: newHypervisorPool(username),
+		idleTimeout: idleTimeout,
+		vms:         map[string]*lazyVM{},
+	}
+}
+
+// get returns the server known by key and its tunnel. resolve looks the
+// server up if it is not known yet.
+func (lt *lazyTunnels) get(key string, resolve func() (*openstack.Info, error)) (*openstack.Info, *tunnel.Lazy, error) {
+	lt.mu.Lock()
+	vm, ok := lt.vms[key]
+	if !ok {
+		vm = &lazyVM{}
+		lt.vms[key] = vm
+	}
+	lt.mu.Unlock()
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.info != nil {
+		return vm.info, vm.lazy, nil
+	}
+
+	info, err := resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+	vm.info = info
+	vm.lazy = tunnel.NewLazy(lt.ctx, func(ctx context.Context) (*tunnel.Tunnel, func(error), error) {
+		c, _, err := lt.hypervisors.get(info.HypervisorHostname)
+		if err != nil {
+			lt.forget(vm, info)
+			return nil, nil, err
+		}
+		t, err := tunnel.New(ctx, c, info)
+		if err != nil {
+			lt.forget(vm, info)
+			return nil, nil, err
+		}
+		fmt.Printf("%s tunnel to %s on %s up\n", time.Now().Format(time.TimeOnly), info.ServerName, info.HypervisorHostname)
+
+		return t, func(err error) {
+			if err != nil {
+				if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+					lt.hypervisors.drop(info.HypervisorHostname, c)
+				}
+				lt.forget(vm, info)
+			}
+			fmt.Printf("%s tunnel to %s closed\n", time.Now().Format(time.TimeOnly), info.ServerName)
+		}, nil
+	}, lt.idleTimeout)
+	return vm.info, vm.lazy, nil
+}
+
+// forget makes the next get look up the server again.
+func (lt *lazyTunnels) forget(vm *lazyVM, info *openstack.Info) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if vm.info == info {
+		vm.info = nil
+	}
+}
+
+// Close tears down all tunnels and closes the connections to the hypervisors.
+func (lt *lazyTunnels) Close() {
+	var lazies []*tunnel.Lazy
+	lt.mu.Lock()
+	for _, vm := range lt.vms {
+		vm.mu.Lock()
+		if vm.lazy != nil {
+			lazies = append(lazies, vm.lazy)
+		}
+		vm.mu.Unlock()
+	}
+	lt.mu.Unlock()
+
+	// not holding the locks, tunnels failing meanwhile forget their server
+	for _, l := range lazies {
+		l.Close()
+	}
+	lt.hypervisors.Close()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"github.com/modzilla99/osssh/internal/openstack/auth"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+)
+
+// loginCommand authenticates to OpenStack, which stores the tokens of
+// interactive logins. With -create-app-credential it bootstraps an
+// application credential for unattended use.
+func loginCommand(ctx context.Context, arguments []string) int {
+	var (
+		cloud         string
+		create        bool
+		rotate        bool
+		name          string
+		file          string
+		expires       time.Duration
+		roles         stringsFlag
+		noAccessRules bool
+		consoleLog    bool
+	)
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	fs.StringVar(&cloud, "cloud", os.Getenv("OS_CLOUD"), "cloud in clouds.yaml to log in to")
+	fs.BoolVar(&create, "create-app-credential", false, "create an application credential and save it in clouds.yaml")
+	fs.BoolVar(&rotate, "rotate", false, "replace the saved application credential with a new one")
+	fs.StringVar(&name, "name", "", "name of the clouds.yaml entry of the application credential (default <cloud>-osssh)")
+	fs.StringVar(&file, "file", "", "clouds.yaml file to save the entry in (default the one in use)")
+	fs.DurationVar(&expires, "expires", 30*24*time.Hour, "lifetime of the application credential, 0 for none")
+	fs.Var(&roles, "role", "role the application credential gets, can be repeated (default all roles of the token)")
+	fs.BoolVar(&noAccessRules, "no-access-rules", false, "don't limit the application credential to the API calls of osssh")
+	fs.BoolVar(&consoleLog, "allow-console-log", false, "allow reading console logs for -verify-host-keys, this allows all server actions like reboot and rebuild")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh login [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	if file == "" && (create || rotate) {
+		var err error
+		file, err = auth.CloudsFile()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	// restricted application credentials can't create others, the entry is
+	// rotated with a login to the cloud it was created from
+	if rotate && name == "" {
+		if base, ok := appCredentialBase(file, cloud); ok {
+			fmt.Printf("Rotating %s with a login to %s\n", cloud, base)
+			name, cloud = cloud, base
+		}
+	}
+
+	osc, err := openstack.CreateClientForCloud(ctx, cloud)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	project, err := osc.ProjectName()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if !create && !rotate {
+		fmt.Printf("Logged in to project %s\n", project)
+		return 0
+	}
+
+	if name == "" {
+		name = "osssh"
+		if cloud != "" {
+			name = cloud + "-osssh"
+		}
+	}
+	previous, err := auth.ReadCloud(file, name)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if previous != nil && !rotate {
+		fmt.Printf("%s already has a cloud %s, use -rotate to replace it\n", file, name)
+		return 1
+	}
+
+	opts := openstack.AppCredentialOpts{
+		// Keystone requires unique names per user
+		Name:  fmt.Sprintf("%s-%s", name, time.Now().UTC().Format("20060102150405")),
+		Roles: roles,
+	}
+	if expires > 0 {
+		opts.ExpiresAt = time.Now().Add(expires).UTC().Truncate(time.Second)
+	}
+	if !noAccessRules {
+		opts.AccessRules, err = osc.AppCredentialAccessRules(consoleLog)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if consoleLog {
+			fmt.Println("Warning: the application credential can reboot, rebuild, resize and stop servers and create images of them")
+		}
+	}
+	ac, err := osc.CreateAppCredential(ctx, opts)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	entry, err := auth.AppCredentialCloud(&clientconfig.ClientOpts{Cloud: cloud}, ac.ID, ac.Secret)
+	if err == nil {
+		comment := fmt.Sprintf("application credential %s of project %s, created by osssh login", ac.Name, project)
+		if !opts.ExpiresAt.IsZero() {
+			comment += ", expires " + opts.ExpiresAt.Format(time.RFC3339)
+		}
+		err = auth.SaveCloud(file, name, comment, entry)
+	}
+	if err != nil {
+		fmt.Println(err)
+		// the secret is lost, don't leave the credential behind
+		if err := osc.DeleteAppCredential(ctx, ac.ID); err != nil {
+			fmt.Printf("Unable to delete application credential %s: %s\n", ac.ID, err)
+		}
+		return 1
+	}
+	fmt.Printf("Saved application credential %s as cloud %s in %s\n", ac.Name, name, file)
+
+	if a, ok := previous["auth"].(map[string]any); ok {
+		if id, ok := a["application_credential_id"].(string); ok && id != "" {
+			if err := osc.DeleteAppCredential(ctx, id); err != nil {
+				fmt.Printf("Unable to delete the replaced application credential %s: %s\n", id, err)
+				return 1
+			}
+			fmt.Printf("Deleted the replaced application credential %s\n", id)
+		}
+	}
+	return 0
+}
+
+// appCredentialBase returns the cloud an entry saved by osssh login under
+// the default name <cloud>-osssh was created from.
+func appCredentialBase(file, cloud string) (string, bool) {
+	base, ok := strings.CutSuffix(cloud, "-osssh")
+	if !ok || base == "" {
+		return "", false
+	}
+	entry, err := auth.ReadCloud(file, cloud)
+	if err != nil || entry == nil {
+		return "", false
+	}
+	a, _ := entry["auth"].(map[string]any)
+	if id, _ := a["application_credential_id"].(string); id == "" {
+		return "", false
+	}
+	return base, true
+}
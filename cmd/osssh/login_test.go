@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppCredentialBase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "clouds.yaml")
+	err := os.WriteFile(file, []byte(`
+clouds:
+  prod:
+    auth:
+      auth_url: https://keystone.example.com/v3
+      username: alice
+  prod-osssh:
+    auth_type: v3applicationcredential
+    auth:
+      auth_url: https://keystone.example.com/v3
+      application_credential_id: 2c1b3f
+      application_credential_secret: s3cret
+  lab-osssh:
+    auth:
+      auth_url: https://keystone.example.com/v3
+      username: bob
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cloud  string
+		want   string
+		wantOk bool
+	}{
+		{cloud: "prod-osssh", want: "prod", wantOk: true},
+		{cloud: "prod"},
+		// not an application credential
+		{cloud: "lab-osssh"},
+		{cloud: "missing-osssh"},
+		{cloud: "-osssh"},
+	}
+	for _, tt := range tests {
+		got, ok := appCredentialBase(file, tt.cloud)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("appCredentialBase(%q) = %q, %t, want %q, %t", tt.cloud, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/modzilla99/osssh/internal/control"
+	utils "github.com/modzilla99/osssh/internal/general"
+	"github.com/modzilla99/osssh/internal/netnsproxy"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// openTunnel opens a tunnel to the server. With -master it goes through the
+// master process for the hypervisor, which is started in the background if
+// none is running.
+func openTunnel(ctx context.Context, info *openstack.Info, args generic.Args) (*tunnel.Tunnel, error) {
+	if !args.Master {
+		return tunnel.Open(ctx, info, args.Username)
+	}
+
+	dir, err := control.MasterDir()
+	if err != nil {
+		return nil, err
+	}
+	id := control.MasterID(info.HypervisorHostname, args.Username)
+	sock := control.SocketPath(dir, id)
+
+	if t, err := tunnel.Attach(ctx, info, sock); err == nil {
+		progress.Printf("Attached to master for %s\n", info.HypervisorHostname)
+		return t, nil
+	}
+
+	// the socket of a master that is gone is left behind
+	os.Remove(sock)
+
+	progress.Printf("Starting master for %s...", info.HypervisorHostname)
+	err = detach([]string{"master", "-u", args.Username, info.HypervisorHostname}, nil, control.LogPath(dir, id), sock)
+	if err != nil {
+		progress.Println("Error")
+		return nil, err
+	}
+	progress.Println("Done")
+
+	return tunnel.Attach(ctx, info, sock)
+}
+
+// masterCommand keeps a connection to a hypervisor open for other osssh
+// processes started with -master, until it has been unused for a while.
+func masterCommand(ctx context.Context, arguments []string) int {
+	var (
+		args    generic.Args
+		persist time.Duration
+		stop    bool
+	)
+	fs := utils.NewFlagSet("master", &args)
+	fs.DurationVar(&persist, "persist", 10*time.Minute, "exit after no process has been attached for this long")
+	fs.BoolVar(&stop, "stop", false, "stop the running master")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh master [flags] hypervisor")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	hypervisor := fs.Arg(0)
+
+	dir, err := control.MasterDir()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	id := control.MasterID(hypervisor, args.Username)
+	sock := control.SocketPath(dir, id)
+
+	if stop {
+		if err := tunnel.StopMaster(sock); err != nil {
+			fmt.Printf("No master running for %s\n", hypervisor)
+			return 1
+		}
+		fmt.Printf("Stopped master for %s\n", hypervisor)
+		return 0
+	}
+
+	if tunnel.PingMaster(sock) == nil {
+		fmt.Printf("Master for %s is already running\n", hypervisor)
+		return 0
+	}
+	os.Remove(sock)
+
+	progress.Print("Connecting to SSH...")
+	c, err := ssh.NewClient(hypervisor, args.Username)
+	if err != nil {
+		progress.Println("Error")
+		fmt.Println(err)
+		return 1
+	}
+	progress.Println("Done")
+	defer c.Close()
+
+	if err := netnsproxy.Setup(c); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.Remove(control.LogPath(dir, id))
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Printf("Master for %s listening on %s\n", hypervisor, sock)
+	if err := tunnel.ServeMaster(ctx, c, listener, persist); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("Master for %s stopped\n", hypervisor)
+	return 0
+}
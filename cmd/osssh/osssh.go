@@ -1,16 +1,23 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"os/exec"
 	"os/signal"
-	"time"
+	"strconv"
+	"syscall"
 
+	"github.com/modzilla99/osssh/internal/control"
 	utils "github.com/modzilla99/osssh/internal/general"
-	"github.com/modzilla99/osssh/internal/netnsproxy"
 	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
 	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
 	"github.com/modzilla99/osssh/types/generic"
 	"golang.org/x/sync/errgroup"
 )
@@ -19,94 +26,271 @@ type Process interface {
 	Close() error
 }
 
-var (
-	hypervisor string
-	username   string
-)
+// commands maps the subcommands to their implementation. They return the
+// exit code of the process.
+var commands = map[string]func(ctx context.Context, arguments []string) int{
+	"ssh":      sshCommand,
+	"exec":     execCommand,
+	"shell":    shellCommand,
+	"cp":       cpCommand,
+	"run":      fanOutCommand,
+	"up":       upCommand,
+	"ps":       psCommand,
+	"stop":     stopCommand,
+	"master":   masterCommand,
+	"hosts":    hostsCommand,
+	"gateway":  gatewayCommand,
+	"serve":    serveCommand,
+	"login":    loginCommand,
+	"projects": projectsCommand,
+}
 
 func main() {
-	args := utils.ParseArgs()
-	username = args.Username
 	ctx := context.Background()
-	osc, err := openstack.CreateClient(ctx)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(ctx, os.Args[2:]))
+		}
+	}
+
+	args := utils.ParseArgs()
+	if args.Background && os.Getenv(control.DaemonEnv) == "" {
+		os.Exit(startBackground())
+	}
+
+	if args.Lazy {
+		if err := runLazy(ctx, args); err != nil {
+			fmt.Printf("Error %s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	i, err := openstack.GetInfo(ctx, osc, args.UUID)
+
+	osc, i, err := getInfo(ctx, args)
 	if err != nil {
 		fmt.Printf("Error\n%s\n", err)
 		os.Exit(1)
 	}
-	hypervisor = i.HypervisorHostname
 
-	if err := run(ctx, i, args); err != nil {
+	if err := run(ctx, osc, i, args); err != nil {
 		fmt.Println("Error")
 		fmt.Printf("Error %s\n", err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context, info *openstack.Info, args generic.Args) error {
-	var cancel context.CancelFunc
-	ctx, cancel = signal.NotifyContext(ctx, os.Interrupt, os.Kill)
-	defer cancel()
+// getInfo authenticates to OpenStack and fetches the info of the server.
+func getInfo(ctx context.Context, args generic.Args) (*openstack.OpenStackClient, *openstack.Info, error) {
+	if args.AllClouds || args.AllRegions {
+		return searchServer(ctx, args)
+	}
+	osc, err := createClient(ctx, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return findServer(ctx, osc, args)
+}
 
-	fmt.Print("Connecting to SSH...")
-	c, err := ssh.NewClient(hypervisor, args.Username)
+// createClient authenticates to the cloud selected with -cloud, scoped to the
+// project selected with -project.
+func createClient(ctx context.Context, args generic.Args) (*openstack.OpenStackClient, error) {
+	osc, err := openstack.CreateClientForProject(ctx, args.Cloud, args.Project)
+	if err != nil {
+		return nil, err
+	}
+	osc.AllProjects = args.AllProjects
+	return osc, nil
+}
+
+// findServer fetches the info of the server. Unless a project is selected,
+// all projects of the user are searched if the server isn't in the current
+// one, the returned client is scoped to the project of the server.
+func findServer(ctx context.Context, osc *openstack.OpenStackClient, args generic.Args) (*openstack.OpenStackClient, *openstack.Info, error) {
+	if args.Project == "" && !args.AllProjects {
+		return openstack.GetInfoInProjects(ctx, osc, args.Server)
+	}
+	info, err := openstack.GetInfo(ctx, osc, args.Server)
+	if err != nil {
+		return nil, nil, err
+	}
+	if args.AllProjects {
+		printOwner(info)
+	}
+	return osc, info, nil
+}
+
+// printOwner shows whose server is entered, names that couldn't be resolved
+// are replaced by their id.
+func printOwner(info *openstack.Info) {
+	project := cmp.Or(info.ProjectName, info.ProjectID)
+	if info.ProjectDomain != "" {
+		project = fmt.Sprintf("%s (domain %s)", project, info.ProjectDomain)
+	}
+	fmt.Printf("%s belongs to project %s, owned by %s\n", info.ServerName, project, cmp.Or(info.OwnerName, info.OwnerID))
+}
+
+// withTunnel opens a tunnel to the server given in args and calls fn with it.
+// The netns-proxies are shut down once fn returns, its result is the exit code.
+func withTunnel(ctx context.Context, args generic.Args, fn func(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel) (int, error)) int {
+	osc, info, err := getInfo(ctx, args)
 	if err != nil {
 		fmt.Printf("Error\n%s\n", err)
-		os.Exit(1)
+		return 1
 	}
-	defer c.Close()
-	fmt.Println("Done")
 
-	path, err := utils.GetNetNSFromNeutronMetadata(c, info.NetworkID)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t, err := openTunnel(ctx, info, args)
 	if err != nil {
-		return err
+		fmt.Println(err)
+		return 1
 	}
+	defer t.Close()
 
-	err = netnsproxy.Setup(c)
+	code, err := fn(ctx, osc, t)
 	if err != nil {
-		return err
+		fmt.Println(err)
 	}
 
-	proxyPort, err := netnsproxy.GetAvailablePort(c)
+	cancel()
+	if err := t.Wait(); err != nil {
+		fmt.Println(err)
+	}
+	return code
+}
+
+// withLocalForward forwards a random local port to remotePort on the server
+// and calls fn with it. The forward is removed once fn returns.
+func withLocalForward(ctx context.Context, t *tunnel.Tunnel, remotePort int, fn func(port int) (int, error)) (int, error) {
+	addr, err := t.Forward("tcp", remotePort)
+	if err != nil {
+		return 1, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 1, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go ssh.ServeDial(ctx, listener, func() (net.Conn, error) {
+		return t.Dial(addr)
+	}, nil)
+
+	return fn(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// runCommand runs cmd and returns its exit code. Interrupts are left to the
+// command while it is running.
+func runCommand(cmd *exec.Cmd) (int, error) {
+	// catch interrupts instead of ignoring them, so they are not ignored by
+	// the command as well
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// forward starts forwarding fw through the tunnel. The local side runs in
+// group until ctx is cancelled.
+func forward(ctx context.Context, group *errgroup.Group, t *tunnel.Tunnel, fw generic.Forward, stats *ssh.Stats) error {
+	addr, err := t.Forward(fw.Type, fw.RemotePort)
 	if err != nil {
 		return err
 	}
-	group, ctx := errgroup.WithContext(ctx)
 
-	group.Go(func() error {
-		fmt.Print("Setting up remote port forwarding...")
-		return netnsproxy.RunNetnsProxy(ctx, c, netnsproxy.NetnsProxyOpts{
-			ListenPort: proxyPort,
-			Address:    info.IPAddress,
-			Path:       path,
-			ProxyPort:  args.RemotePort,
+	progress.Print("Setting up local port forwarding...")
+	switch fw.Type {
+	case "udp":
+		relay, err := t.UDPRelay(addr)
+		if err != nil {
+			progress.Println("Error")
+			return err
+		}
+		group.Go(func() error {
+			return ssh.UDPForward(ctx, relay, fw.LocalAddress(), stats)
 		})
-	})
-
-	time.Sleep(200 * time.Millisecond)
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("failed to setup port-forwarding")
 	default:
-		println("Done")
+		listener, err := net.Listen("tcp", fw.LocalAddress())
+		if err != nil {
+			progress.Println("Error")
+			return err
+		}
+		group.Go(func() error {
+			return ssh.ServeDial(ctx, listener, func() (net.Conn, error) {
+				return t.Dial(addr)
+			}, stats)
+		})
 	}
+	progress.Println("Done")
+	return nil
+}
+
+func run(ctx context.Context, osc *openstack.OpenStackClient, info *openstack.Info, args generic.Args) error {
+	var cancel context.CancelFunc
+	ctx, cancel = signal.NotifyContext(ctx, os.Interrupt, os.Kill, syscall.SIGTERM)
+	defer cancel()
 
-	fmt.Print("Setting up local port forwarding...")
+	group, ctx := errgroup.WithContext(ctx)
+	stats := &ssh.Stats{}
 
-	group.Go(func() error {
-		return ssh.PortForward(ctx, c, args.Port, generic.AddressPort{
-			Address: "127.0.0.1",
-			Port:    proxyPort,
-			Type:    "tcp",
+	t, err := openTunnel(ctx, info, args)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	group.Go(t.Wait)
+
+	for _, fw := range args.Forwards {
+		err := forward(ctx, group, t, fw, stats)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Forwarding %s:%d/%s (%s on %s) from network %s to %s\n",
+			info.IPAddress, fw.RemotePort, fw.Type, info.ServerName, location(info), info.NetworkID, fw.LocalAddress())
+	}
+
+	for _, r := range args.Reverse {
+		allowed, err := openstack.EgressAllowed(ctx, osc, info, "tcp", r.BindAddress, r.RemotePort)
+		if err != nil {
+			fmt.Printf("Warning: unable to check security groups: %s\n", err)
+		} else if !allowed {
+			fmt.Printf("Warning: security groups of %s do not allow egress to %s:%d/tcp\n", info.ServerName, r.BindAddress, r.RemotePort)
+		}
+
+		listener, err := t.Reverse(r.BindAddress, r.RemotePort)
+		if err != nil {
+			return err
+		}
+		target := net.JoinHostPort(r.LocalHost, strconv.Itoa(r.LocalPort))
+		group.Go(func() error {
+			return ssh.ReverseForward(ctx, listener, target)
 		})
-	})
 
-	fmt.Printf("Done\nForwarding %s:%d (%s on %s) from network %s to 127.0.0.1:%d\n",
-		info.IPAddress, args.RemotePort, info.ServerName, info.HypervisorHostname, info.NetworkID, args.Port)
+		fmt.Printf("Forwarding %s:%d in network %s (reachable from %s) to %s\n",
+			r.BindAddress, r.RemotePort, info.NetworkID, info.ServerName, target)
+	}
+
+	if id := os.Getenv(control.DaemonEnv); id != "" {
+		unregister, err := serveControl(cancel, id, func() *openstack.Info { return info }, args, stats)
+		if err != nil {
+			return err
+		}
+		// the netns-proxies are shut down once group is done
+		defer unregister()
+	}
 
 	return group.Wait()
 }
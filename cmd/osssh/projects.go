@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+)
+
+// projectsCommand lists the projects the user can scope to with -project.
+func projectsCommand(ctx context.Context, arguments []string) int {
+	fs := flag.NewFlagSet("projects", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh projects")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	osc, err := openstack.CreateClient(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	ps, err := osc.Projects(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	current, _ := osc.ProjectID()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tID\tNAME\tDOMAIN\tENABLED")
+	for _, p := range ps {
+		mark := ""
+		if p.ID == current {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", mark, p.ID, p.Name, p.DomainID, p.Enabled)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runResult is the outcome of running the command on a single server.
+type runResult struct {
+	Server     string `json:"server"`
+	ServerID   string `json:"server_id"`
+	Hypervisor string `json:"hypervisor"`
+	ExitCode   int    `json:"exit_code"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// prefixWriter writes every line prefixed with the name of the server.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, "%s %s", w.prefix, line)
+}
+
+// fanOutCommand runs a command on all matching servers with the embedded SSH
+// client. Servers on the same hypervisor share one connection to it.
+func fanOutCommand(ctx context.Context, arguments []string) int {
+	var (
+		args     generic.Args
+		vm       vmFlags
+		filter   openstack.ServerFilter
+		servers  stringsFlag
+		all      bool
+		parallel int
+		asJSON   bool
+	)
+	fs := utils.NewFlagSet("run", &args)
+	utils.ScopeFlags(fs, &args)
+	vm.register(fs)
+	fs.Var((*stringsFlag)(&filter.Tags), "tag", "only run on servers with this Nova tag, can be repeated")
+	fs.StringVar(&filter.ServerGroup, "server-group", "", "only run on members of this server group (name or id)")
+	fs.Var(&servers, "server", "run on this server (name or id), can be repeated")
+	fs.BoolVar(&all, "all", false, "run on all servers of the project")
+	fs.IntVar(&parallel, "parallel", 10, "maximum number of servers to run on concurrently")
+	fs.BoolVar(&asJSON, "json", false, "print the results as JSON")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh run [flags] -- command [args]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	command := strings.Join(fs.Args(), " ")
+	if command == "" || (!all && len(servers) == 0 && len(filter.Tags) == 0 && filter.ServerGroup == "") {
+		fs.Usage()
+		return 1
+	}
+	if args.Username == "" {
+		fmt.Println("Cannot get username from environment, please specify username with -u")
+		return 1
+	}
+
+	// progress of concurrent tunnels would be interleaved and prompting for
+	// host keys is not possible, errors are part of the results instead
+	vm.batch = true
+	progress.Output = io.Discard
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	osc, err := createClient(ctx, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	infos, err := openstack.FindServers(ctx, osc, filter)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if len(servers) > 0 {
+		infos = slices.DeleteFunc(infos, func(i *openstack.Info) bool {
+			return !slices.Contains(servers, i.ServerName) && !slices.Contains(servers, i.ServerID)
+		})
+	}
+	if len(infos) == 0 {
+		fmt.Println("No matching servers found")
+		return 1
+	}
+
+	hypervisors := newHypervisorPool(args.Username)
+	defer hypervisors.Close()
+
+	var (
+		outMu   sync.Mutex
+		results = make([]runResult, len(infos))
+		group   errgroup.Group
+	)
+	group.SetLimit(parallel)
+	for n, info := range infos {
+		group.Go(func() error {
+			r := runResult{
+				Server:     info.ServerName,
+				ServerID:   info.ServerID,
+				Hypervisor: info.HypervisorHostname,
+			}
+
+			var stdout, stderr io.Writer
+			var stdoutBuf, stderrBuf bytes.Buffer
+			if asJSON {
+				stdout, stderr = &stdoutBuf, &stderrBuf
+			} else {
+				prefix := fmt.Sprintf("[%s]", info.ServerName)
+				o := &prefixWriter{mu: &outMu, out: os.Stdout, prefix: prefix}
+				e := &prefixWriter{mu: &outMu, out: os.Stderr, prefix: prefix}
+				defer o.Flush()
+				defer e.Flush()
+				stdout, stderr = o, e
+			}
+
+			code, err := runOnServer(ctx, osc, hypervisors, info, vm, command, stdout, stderr)
+			r.ExitCode = code
+			r.Stdout, r.Stderr = stdoutBuf.String(), stderrBuf.String()
+			if err != nil {
+				r.Error = err.Error()
+			}
+			results[n] = r
+			return nil
+		})
+	}
+	group.Wait()
+
+	exitCode := 0
+	for _, r := range results {
+		if r.ExitCode != 0 {
+			exitCode = 1
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return exitCode
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tHYPERVISOR\tEXIT\tERROR")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Server, r.Hypervisor, r.ExitCode, r.Error)
+	}
+	w.Flush()
+	return exitCode
+}
+
+// runOnServer runs command on the server through a tunnel on the shared
+// connection to its hypervisor.
+func runOnServer(ctx context.Context, osc *openstack.OpenStackClient, hypervisors *hypervisorPool, info *openstack.Info, vm vmFlags, command string, stdout, stderr io.Writer) (int, error) {
+	c, _, err := hypervisors.get(info.HypervisorHostname)
+	if err != nil {
+		return 255, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t, err := tunnel.New(ctx, c, info)
+	if err != nil {
+		return 255, err
+	}
+	defer func() {
+		cancel()
+		t.Wait()
+	}()
+
+	vc, err := dialVM(ctx, osc, t, vm)
+	if err != nil {
+		return 255, err
+	}
+	defer vc.Close()
+
+	s, err := ssh.GetSession(vc)
+	if err != nil {
+		return 255, err
+	}
+	defer s.Close()
+	s.Stdout = stdout
+	s.Stderr = stderr
+
+	err = s.Run(command)
+	var exitErr *gossh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 255, err
+	}
+	return 0, nil
+}
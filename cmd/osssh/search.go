@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/modzilla99/osssh/internal/openstack/auth"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/types/generic"
+	"golang.org/x/sync/errgroup"
+)
+
+// searchServer looks for the server in all clouds of clouds.yaml with
+// -all-clouds and in all regions of the catalog with -all-regions. The
+// returned client uses the session and region of the first match.
+func searchServer(ctx context.Context, args generic.Args) (*openstack.OpenStackClient, *openstack.Info, error) {
+	clouds := []string{args.Cloud}
+	if args.AllClouds {
+		names, err := auth.CloudNames()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(names) == 0 {
+			return nil, nil, errors.New("no clouds found in clouds.yaml")
+		}
+		clouds = names
+	}
+
+	progress.Printf("Authenticating to %d clouds...", len(clouds))
+	clients, errs := authenticateClouds(ctx, clouds, args.Project, args.AllProjects)
+	if len(clients) == 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	progress.Println("Done")
+	// a cloud failing to authenticate only matters if the server isn't found
+	for _, err := range errs {
+		fmt.Printf("Warning: %s\n", err)
+	}
+
+	if args.AllRegions {
+		var all []*openstack.OpenStackClient
+		for _, osc := range clients {
+			regions, err := osc.Regions()
+			if err != nil || len(regions) == 0 {
+				all = append(all, osc)
+				continue
+			}
+			for _, r := range regions {
+				all = append(all, osc.InRegion(r))
+			}
+		}
+		clients = all
+	}
+
+	progress.Printf("Searching %s in %d clouds and regions...", args.Server, len(clients))
+	osc, info, err := openstack.SearchServer(ctx, clients, args.Server)
+	if err != nil {
+		return nil, nil, err
+	}
+	progress.Println("Done")
+	if name := osc.CloudName(); name != "" {
+		progress.Printf("Found %s in cloud %s\n", info.ServerName, name)
+	}
+	if args.AllProjects {
+		printOwner(info)
+	}
+	return osc, info, nil
+}
+
+// authenticateClouds authenticates to the clouds in parallel, scoped to the
+// project if one is given and looking up servers in all projects with
+// allProjects. The errors of the clouds that failed are returned with the
+// clients of the others.
+func authenticateClouds(ctx context.Context, clouds []string, project string, allProjects bool) ([]*openstack.OpenStackClient, []error) {
+	// the progress messages of the clouds would be interleaved
+	out := progress.Output
+	progress.Output = io.Discard
+	defer func() { progress.Output = out }()
+
+	var (
+		mu      sync.Mutex
+		clients []*openstack.OpenStackClient
+		errs    []error
+	)
+	g := errgroup.Group{}
+	g.SetLimit(8)
+	for _, cloud := range clouds {
+		g.Go(func() error {
+			osc, err := openstack.CreateClientForProject(ctx, cloud, project)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if cloud == "" {
+					cloud = "of the environment"
+				}
+				errs = append(errs, fmt.Errorf("unable to authenticate to cloud %s: %w", cloud, err))
+				return nil
+			}
+			osc.AllProjects = allProjects
+			clients = append(clients, osc)
+			return nil
+		})
+	}
+	g.Wait()
+	return clients, errs
+}
+
+// location describes where the server runs: its hypervisor and, if known,
+// its region.
+func location(info *openstack.Info) string {
+	if info.Region == "" {
+		return info.HypervisorHostname
+	}
+	return fmt.Sprintf("%s in region %s", info.HypervisorHostname, info.Region)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/types/generic"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// bastionUserExtension carries the name of the authenticated user in the
+// permissions of an SSH connection.
+const bastionUserExtension = "osssh-user"
+
+// bastionUser is a user of the bastion. Their OpenStack client is
+// authenticated on first use.
+type bastionUser struct {
+	config generic.ServeUser
+
+	mu  sync.Mutex
+	osc *openstack.OpenStackClient
+}
+
+func (u *bastionUser) client(ctx context.Context) (*openstack.OpenStackClient, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.osc != nil {
+		return u.osc, nil
+	}
+
+	osc, err := openstack.CreateClientForCloud(ctx, u.config.Cloud)
+	if err != nil {
+		return nil, err
+	}
+	u.osc = osc
+	return osc, nil
+}
+
+// bastion forwards the direct-tcpip channels of its users to servers, which
+// are looked up with the OpenStack identity of the user.
+type bastion struct {
+	ctx     context.Context
+	users   map[string]*bastionUser
+	keys    map[string]*bastionUser
+	tunnels *lazyTunnels
+}
+
+// directTCPIP is the payload of a direct-tcpip channel request, see RFC 4254
+// section 7.2.
+type directTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// serveCommand runs osssh as an SSH server to be used as a jump host, e.g.
+// with ssh -J. The target of a forward is the name, uuid or address of a
+// server.
+func serveCommand(ctx context.Context, arguments []string) int {
+	var (
+		args        generic.Args
+		file        string
+		idleTimeout time.Duration
+	)
+	fs := utils.NewFlagSet("serve", &args)
+	fs.StringVar(&file, "c", "serve.yaml", "configuration file")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 5*time.Minute, "tear tunnels down after no connection has been open for this long")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh serve [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	config, err := utils.LoadServeConfig(file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if config.HypervisorUser != "" {
+		args.Username = config.HypervisorUser
+	}
+
+	hostKey, err := loadHostKey(config.HostKey)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// connections are handled concurrently, they are logged instead
+	progress.Output = io.Discard
+
+	b := &bastion{
+		ctx:     ctx,
+		users:   map[string]*bastionUser{},
+		keys:    map[string]*bastionUser{},
+		tunnels: newLazyTunnels(ctx, args.Username, idleTimeout),
+	}
+	defer b.tunnels.Close()
+	for _, u := range config.Users {
+		user := &bastionUser{config: u}
+		b.users[u.Name] = user
+		for _, k := range u.Keys {
+			pk, _, _, _, _ := gossh.ParseAuthorizedKey([]byte(k))
+			b.keys[string(pk.Marshal())] = user
+		}
+	}
+
+	sshConfig := &gossh.ServerConfig{PublicKeyCallback: b.authenticate}
+	sshConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Printf("Listening on %s, host key %s\n", listener.Addr(), gossh.FingerprintSHA256(hostKey.PublicKey()))
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println(err)
+			}
+			break
+		}
+		wg.Go(func() {
+			b.handleConn(conn, sshConfig)
+		})
+	}
+	wg.Wait()
+	return 0
+}
+
+// loadHostKey reads the host key, a new ed25519 key is created if the file
+// does not exist.
+func loadHostKey(file string) (gossh.Signer, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := gossh.MarshalPrivateKey(key, "osssh host key")
+		if err != nil {
+			return nil, err
+		}
+		content = pem.EncodeToMemory(block)
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Created host key %s\n", file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	signer, err := gossh.ParsePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse host key %s: %w", file, err)
+	}
+	return signer, nil
+}
+
+func (b *bastion) authenticate(meta gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+	u, ok := b.keys[string(key.Marshal())]
+	if !ok {
+		return nil, fmt.Errorf("unknown public key for %s", meta.User())
+	}
+	return &gossh.Permissions{Extensions: map[string]string{bastionUserExtension: u.config.Name}}, nil
+}
+
+func (b *bastion) handleConn(conn net.Conn, config *gossh.ServerConfig) {
+	sc, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		fmt.Printf("%s %s: %s\n", time.Now().Format(time.TimeOnly), conn.RemoteAddr(), err)
+		return
+	}
+	defer sc.Close()
+	stop := context.AfterFunc(b.ctx, func() { sc.Close() })
+	defer stop()
+
+	user := b.users[sc.Permissions.Extensions[bastionUserExtension]]
+	fmt.Printf("%s %s connected from %s\n", time.Now().Format(time.TimeOnly), user.config.Name, sc.RemoteAddr())
+	go gossh.DiscardRequests(reqs)
+
+	for ch := range chans {
+		if ch.ChannelType() != "direct-tcpip" {
+			ch.Reject(gossh.UnknownChannelType, "only forwarding to servers is supported")
+			continue
+		}
+		go b.forward(user, ch)
+	}
+	fmt.Printf("%s %s disconnected\n", time.Now().Format(time.TimeOnly), user.config.Name)
+}
+
+// forward connects the channel to the server it is addressed to, if the
+// user can see it.
+func (b *bastion) forward(user *bastionUser, ch gossh.NewChannel) {
+	var d directTCPIP
+	if err := gossh.Unmarshal(ch.ExtraData(), &d); err != nil {
+		ch.Reject(gossh.ConnectionFailed, "invalid forward request")
+		return
+	}
+	target := net.JoinHostPort(d.Host, fmt.Sprint(d.Port))
+
+	fail := func(err error) {
+		fmt.Printf("%s %s -> %s: %s\n", time.Now().Format(time.TimeOnly), user.config.Name, target, err)
+		ch.Reject(gossh.ConnectionFailed, err.Error())
+	}
+
+	osc, err := user.client(b.ctx)
+	if err != nil {
+		fail(fmt.Errorf("unable to authenticate to OpenStack: %w", err))
+		return
+	}
+
+	// tunnels are not shared between users, every user reaches the servers
+	// with their own identity
+	info, lazy, err := b.tunnels.get(user.config.Name+"/"+d.Host, func() (*openstack.Info, error) {
+		if net.ParseIP(d.Host) != nil {
+			return openstack.GetInfoByAddress(b.ctx, osc, d.Host)
+		}
+		return openstack.GetInfo(b.ctx, osc, d.Host)
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	remote, err := lazy.Dial("tcp", int(d.Port))
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer remote.Close()
+
+	channel, reqs, err := ch.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go gossh.DiscardRequests(reqs)
+
+	fmt.Printf("%s %s -> %s (%s on %s):%d\n", time.Now().Format(time.TimeOnly),
+		user.config.Name, d.Host, info.ServerName, info.HypervisorHostname, d.Port)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(channel, remote)
+		channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(remote, channel)
+		done <- struct{}{}
+	}()
+	<-done
+}
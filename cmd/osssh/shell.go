@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// vmFlags configures the embedded SSH connection to a VM.
+type vmFlags struct {
+	login      string
+	port       int
+	knownHosts string
+	verify     bool
+	acceptNew  bool
+	// batch disables prompting for unknown host keys
+	batch bool
+}
+
+func (f *vmFlags) register(fs *flag.FlagSet) {
+	username, _ := os.LookupEnv("USER")
+	fs.StringVar(&f.login, "l", username, "user to log in as on the VM")
+	fs.IntVar(&f.port, "r", 22, "port of the SSH server on the VM")
+	fs.StringVar(&f.knownHosts, "known-hosts", "", "known_hosts file for VMs (default: osssh known_hosts in user config dir)")
+	fs.BoolVar(&f.verify, "verify-host-keys", false, "verify the host keys of the VM against the fingerprints in its console output")
+	fs.BoolVar(&f.acceptNew, "accept-new-host-keys", false, "add unknown host keys to known_hosts without asking")
+}
+
+func (f *vmFlags) confirm() func(host string, key gossh.PublicKey) bool {
+	switch {
+	case f.acceptNew:
+		return ssh.AcceptHostKey
+	case f.batch:
+		return nil
+	default:
+		return ssh.ConfirmHostKey
+	}
+}
+
+// dialVM opens an SSH connection to the VM through the tunnel.
+func dialVM(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel, f vmFlags) (*gossh.Client, error) {
+	knownHosts := f.knownHosts
+	if knownHosts == "" {
+		var err error
+		knownHosts, err = ssh.KnownHostsFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr, err := t.Forward("tcp", f.port)
+	if err != nil {
+		return nil, err
+	}
+	dial := func() (net.Conn, error) {
+		return t.Dial(addr)
+	}
+
+	if f.verify {
+		if err := verifyHostKeys(ctx, osc, t.Info, dial, knownHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	callback, err := ssh.TrustOnFirstUse(knownHosts, f.confirm())
+	if err != nil {
+		return nil, err
+	}
+
+	// the keypair may belong to another user, agent keys are matched by
+	// their comment then
+	var publicKey string
+	if t.Info.KeyName != "" {
+		publicKey, _ = openstack.GetKeyPairPublicKey(ctx, osc, t.Info.KeyName)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	c, err := ssh.NewVMClient(conn, ssh.VMClientOpts{
+		Username:        f.login,
+		HostKeyAlias:    ssh.HostKeyAlias(t.Info.ServerID),
+		HostKeyCallback: callback,
+		KeyName:         t.Info.KeyName,
+		PublicKey:       publicKey,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// shellCommand opens an interactive shell on the server with the embedded
+// SSH client.
+func shellCommand(ctx context.Context, arguments []string) int {
+	var (
+		args generic.Args
+		vm   vmFlags
+	)
+	fs := utils.NewFlagSet("shell", &args)
+	utils.MasterFlag(fs, &args)
+	utils.ScopeFlags(fs, &args)
+	utils.SearchFlags(fs, &args)
+	vm.register(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh shell [flags] server [-- command]")
+		fs.PrintDefaults()
+	}
+	extra := utils.ParseSubcommand(fs, &args, arguments)
+
+	return withTunnel(ctx, args, func(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel) (int, error) {
+		c, err := dialVM(ctx, osc, t, vm)
+		if err != nil {
+			return 1, err
+		}
+		defer c.Close()
+
+		return ssh.Shell(c, strings.Join(extra, " "))
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// sshCommand runs the system ssh against a tunnel to the server.
+func sshCommand(ctx context.Context, arguments []string) int {
+	var (
+		args       generic.Args
+		login      string
+		knownHosts string
+		verify     bool
+	)
+	fs := utils.NewFlagSet("ssh", &args)
+	utils.MasterFlag(fs, &args)
+	utils.ScopeFlags(fs, &args)
+	utils.SearchFlags(fs, &args)
+	fs.StringVar(&login, "l", "", "user to log in as on the VM")
+	fs.IntVar(&args.RemotePort, "r", 22, "port of the SSH server on the VM")
+	fs.StringVar(&knownHosts, "known-hosts", "", "known_hosts file for VMs (default: osssh known_hosts in user config dir)")
+	fs.BoolVar(&verify, "verify-host-keys", false, "verify the host keys of the VM against the fingerprints in its console output")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh ssh [flags] server [-- ssh args]")
+		fs.PrintDefaults()
+	}
+	extra := utils.ParseSubcommand(fs, &args, arguments)
+
+	if knownHosts == "" {
+		var err error
+		knownHosts, err = ssh.KnownHostsFile()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	return withTunnel(ctx, args, func(ctx context.Context, osc *openstack.OpenStackClient, t *tunnel.Tunnel) (int, error) {
+		return withLocalForward(ctx, t, args.RemotePort, func(port int) (int, error) {
+			sshArgs := []string{
+				"-p", strconv.Itoa(port),
+				"-o", "HostKeyAlias=" + ssh.HostKeyAlias(t.Info.ServerID),
+				"-o", "UserKnownHostsFile=" + knownHosts,
+			}
+			if verify {
+				address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+				dial := func() (net.Conn, error) {
+					return net.Dial("tcp", address)
+				}
+				if err := verifyHostKeys(ctx, osc, t.Info, dial, knownHosts); err != nil {
+					return 1, err
+				}
+				sshArgs = append(sshArgs, "-o", "StrictHostKeyChecking=yes")
+			}
+			if login != "" {
+				sshArgs = append(sshArgs, "-l", login)
+			}
+			sshArgs = append(sshArgs, "127.0.0.1")
+			sshArgs = append(sshArgs, extra...)
+
+			cmd := exec.Command("ssh", sshArgs...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return runCommand(cmd)
+		})
+	})
+}
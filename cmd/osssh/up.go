@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/internal/tunnel"
+	"github.com/modzilla99/osssh/types/generic"
+	"golang.org/x/sync/errgroup"
+)
+
+const upRetryInterval = 5 * time.Second
+
+// tunnelGroup holds all forwards to one server, they share a tunnel.
+type tunnelGroup struct {
+	cloud  string
+	server string
+	// name is shown instead of server if set
+	name     string
+	network  string
+	forwards []generic.Forward
+
+	mu     sync.Mutex
+	info   *openstack.Info
+	status string
+}
+
+func (g *tunnelGroup) setStatus(status string) {
+	g.mu.Lock()
+	changed := g.status != status
+	g.status = status
+	g.mu.Unlock()
+
+	if changed {
+		fmt.Printf("%s %s: %s\n", time.Now().Format(time.TimeOnly), g.label(), status)
+	}
+}
+
+func (g *tunnelGroup) label() string {
+	if g.name != "" {
+		return g.name
+	}
+	return g.server
+}
+
+func (g *tunnelGroup) String() string {
+	fws := make([]string, 0, len(g.forwards))
+	for _, fw := range g.forwards {
+		fws = append(fws, fmt.Sprintf("%s->%d/%s", fw.LocalAddress(), fw.RemotePort, fw.Type))
+	}
+	return strings.Join(fws, ", ")
+}
+
+// upCommand brings up all tunnels listed in a tunnels file and keeps them
+// alive until interrupted.
+func upCommand(ctx context.Context, arguments []string) int {
+	var (
+		args        generic.Args
+		file        string
+		lazy        bool
+		idleTimeout time.Duration
+	)
+	fs := utils.NewFlagSet("up", &args)
+	fs.StringVar(&file, "f", "tunnels.yaml", "file listing the tunnels")
+	fs.BoolVar(&lazy, "lazy", false, "only bind the local listeners, bring tunnels up on their first connection")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 5*time.Minute, "tear lazy tunnels down after no connection has been open for this long")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh up [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+
+	tf, err := utils.LoadTunnelsFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	groups, err := groupTunnels(tf.Tunnels)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if lazy {
+		for _, g := range groups {
+			for _, fw := range g.forwards {
+				if fw.Type != "tcp" {
+					fmt.Printf("%s: lazy tunnels only support tcp forwards\n", g.server)
+					return 1
+				}
+			}
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
+	defer cancel()
+
+	// tunnels are brought up concurrently, their status is reported instead
+	progress.Output = io.Discard
+
+	clients, err := createClients(ctx, groups)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	hypervisors := newHypervisorPool(args.Username)
+	defer hypervisors.Close()
+
+	var (
+		wg      sync.WaitGroup
+		started sync.WaitGroup
+	)
+	started.Add(len(groups))
+	for _, g := range groups {
+		if lazy {
+			wg.Go(func() {
+				runLazyTunnelGroup(ctx, clients[g.cloud], hypervisors, g, idleTimeout, started.Done)
+			})
+			continue
+		}
+		wg.Go(func() {
+			keepTunnelUp(ctx, clients[g.cloud], hypervisors, g, started.Done)
+		})
+	}
+
+	started.Wait()
+	printTunnelTable(groups)
+
+	wg.Wait()
+	fmt.Println("All tunnels stopped")
+	return 0
+}
+
+// groupTunnels merges the tunnels to the same server and network.
+func groupTunnels(specs []generic.TunnelSpec) ([]*tunnelGroup, error) {
+	var groups []*tunnelGroup
+	byKey := map[string]*tunnelGroup{}
+	for _, s := range specs {
+		fw, err := utils.TunnelForward(s)
+		if err != nil {
+			return nil, err
+		}
+
+		key := s.Cloud + "/" + s.Server + "/" + s.Network
+		g, ok := byKey[key]
+		if !ok {
+			g = &tunnelGroup{cloud: s.Cloud, server: s.Server, network: s.Network, status: "pending"}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.forwards = append(g.forwards, fw)
+	}
+	return groups, nil
+}
+
+// createClients authenticates to every cloud used by the tunnels.
+func createClients(ctx context.Context, groups []*tunnelGroup) (map[string]*openstack.OpenStackClient, error) {
+	var (
+		mu      sync.Mutex
+		group   errgroup.Group
+		clients = map[string]*openstack.OpenStackClient{}
+	)
+	for _, g := range groups {
+		mu.Lock()
+		_, ok := clients[g.cloud]
+		clients[g.cloud] = nil
+		mu.Unlock()
+		if ok {
+			continue
+		}
+
+		group.Go(func() error {
+			osc, err := openstack.CreateClientForCloud(ctx, g.cloud)
+			if err != nil {
+				if g.cloud == "" {
+					return err
+				}
+				return fmt.Errorf("cloud %s: %w", g.cloud, err)
+			}
+			mu.Lock()
+			clients[g.cloud] = osc
+			mu.Unlock()
+			return nil
+		})
+	}
+	return clients, group.Wait()
+}
+
+// keepTunnelUp runs the tunnel group until ctx is cancelled. Failed tunnels
+// are retried, the server is looked up again first in case it moved to
+// another hypervisor. started is called after the first attempt.
+func keepTunnelUp(ctx context.Context, osc *openstack.OpenStackClient, hypervisors *hypervisorPool, g *tunnelGroup, started func()) {
+	var once sync.Once
+	defer once.Do(started)
+
+	for {
+		g.setStatus("connecting")
+		err := runTunnelGroup(ctx, osc, hypervisors, g, func() {
+			g.setStatus("up")
+			once.Do(started)
+		})
+		if ctx.Err() != nil {
+			g.setStatus("stopped")
+			return
+		}
+
+		g.setStatus(fmt.Sprintf("failed (%s), retrying in %s", err, upRetryInterval))
+		once.Do(started)
+
+		select {
+		case <-ctx.Done():
+			g.setStatus("stopped")
+			return
+		case <-time.After(upRetryInterval):
+		}
+	}
+}
+
+// runTunnelGroup brings up the forwards of the group on a shared hypervisor
+// connection and blocks until one of them fails or ctx is cancelled.
+func runTunnelGroup(ctx context.Context, osc *openstack.OpenStackClient, hypervisors *hypervisorPool, g *tunnelGroup, up func()) error {
+	info, err := openstack.GetInfoOnNetwork(ctx, osc, g.server, g.network)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.info = info
+	g.mu.Unlock()
+
+	c, connClosed, err := hypervisors.get(info.HypervisorHostname)
+	if err != nil {
+		return err
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	t, err := tunnel.New(ctx, c, info)
+	if err != nil {
+		return err
+	}
+	group.Go(t.Wait)
+
+	// a broken connection to the hypervisor fails all tunnels using it
+	group.Go(func() error {
+		select {
+		case <-connClosed:
+			return fmt.Errorf("connection to %s closed", info.HypervisorHostname)
+		case <-ctx.Done():
+			return nil
+		}
+	})
+
+	for _, fw := range g.forwards {
+		if err := forward(ctx, group, t, fw, nil); err != nil {
+			group.Go(func() error { return err })
+			break
+		}
+	}
+	if ctx.Err() == nil {
+		up()
+	}
+
+	err = group.Wait()
+	select {
+	case <-connClosed:
+		hypervisors.drop(info.HypervisorHostname, c)
+	default:
+	}
+	return err
+}
+
+// runLazyTunnelGroup binds the local listeners of the group and brings its
+// tunnel up on the first connection until ctx is cancelled. started is
+// called once the listeners are bound. Idle tunnels stop their netns-proxies,
+// the connection to the hypervisor is kept for the other tunnels.
+func runLazyTunnelGroup(ctx context.Context, osc *openstack.OpenStackClient, hypervisors *hypervisorPool, g *tunnelGroup, idleTimeout time.Duration, started func()) {
+	var once sync.Once
+	defer once.Do(started)
+
+	lazy := tunnel.NewLazy(ctx, func(ctx context.Context) (*tunnel.Tunnel, func(error), error) {
+		g.setStatus("connecting")
+		info, err := openstack.GetInfoOnNetwork(ctx, osc, g.server, g.network)
+		if err != nil {
+			g.setStatus(fmt.Sprintf("failed (%s)", err))
+			return nil, nil, err
+		}
+		g.mu.Lock()
+		g.info = info
+		g.mu.Unlock()
+
+		c, _, err := hypervisors.get(info.HypervisorHostname)
+		if err != nil {
+			g.setStatus(fmt.Sprintf("failed (%s)", err))
+			return nil, nil, err
+		}
+		t, err := tunnel.New(ctx, c, info)
+		if err != nil {
+			g.setStatus(fmt.Sprintf("failed (%s)", err))
+			return nil, nil, err
+		}
+
+		g.setStatus("up")
+		return t, func(err error) {
+			if err != nil {
+				// reconnect next time if the connection is broken
+				if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+					hypervisors.drop(info.HypervisorHostname, c)
+				}
+			}
+			g.setStatus("idle")
+		}, nil
+	}, idleTimeout)
+	defer lazy.Close()
+
+	group, ctx := errgroup.WithContext(ctx)
+	for _, fw := range g.forwards {
+		listener, err := net.Listen("tcp", fw.LocalAddress())
+		if err != nil {
+			g.setStatus(fmt.Sprintf("failed (%s)", err))
+			group.Go(func() error { return err })
+			break
+		}
+		group.Go(func() error {
+			return ssh.ServeDial(ctx, listener, func() (net.Conn, error) {
+				return lazy.Dial(fw.Type, fw.RemotePort)
+			}, nil)
+		})
+	}
+	if ctx.Err() == nil {
+		g.setStatus("idle")
+	}
+	once.Do(started)
+
+	group.Wait()
+	g.setStatus("stopped")
+}
+
+func printTunnelTable(groups []*tunnelGroup) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tCLOUD\tHYPERVISOR\tADDRESS\tFORWARDS\tSTATUS")
+	for _, g := range groups {
+		g.mu.Lock()
+		hypervisor, address := "-", "-"
+		if g.info != nil {
+			hypervisor, address = g.info.HypervisorHostname, g.info.IPAddress
+		}
+		cloud := g.cloud
+		if cloud == "" {
+			cloud = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", g.label(), cloud, hypervisor, address, g, g.status)
+		g.mu.Unlock()
+	}
+	w.Flush()
+}
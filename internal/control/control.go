@@ -0,0 +1,232 @@
+// Package control keeps track of tunnels running in the background. Every
+// tunnel registers itself in a per-user state directory and answers status
+// and stop requests on a Unix control socket. Master processes sharing a
+// connection to a hypervisor keep their sockets in the same directory.
+package control
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/modzilla99/osssh/internal/state"
+)
+
+// DaemonEnv is set to the id of the tunnel in the environment of osssh
+// processes started in the background.
+const DaemonEnv = "OSSSH_DAEMON_ID"
+
+// Tunnel describes a tunnel running in the background.
+type Tunnel struct {
+	ID         string    `json:"id"`
+	PID        int       `json:"pid"`
+	Server     string    `json:"server"`
+	ServerID   string    `json:"server_id"`
+	Hypervisor string    `json:"hypervisor"`
+	Forwards   []string  `json:"forwards"`
+	Started    time.Time `json:"started"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+}
+
+type request struct {
+	Command string `json:"command"`
+}
+
+type response struct {
+	Tunnel *Tunnel `json:"tunnel,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// StateDir returns the directory background tunnels are registered in and
+// makes sure it exists.
+func StateDir() (string, error) {
+	return state.Dir("tunnels")
+}
+
+// MasterDir returns the directory the control sockets of master processes
+// are created in and makes sure it exists.
+func MasterDir() (string, error) {
+	return state.Dir("masters")
+}
+
+// MasterID returns the id of the master process for the connection as
+// username to the hypervisor. Hostnames are hashed to stay within the length
+// limit of socket paths.
+func MasterID(hypervisor, username string) string {
+	sum := sha256.Sum256([]byte(username + "@" + hypervisor))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewID returns a random id for a tunnel.
+func NewID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SocketPath returns the path of the control socket of the tunnel.
+func SocketPath(dir, id string) string {
+	return filepath.Join(dir, id+".sock")
+}
+
+// LogPath returns the path of the log file of the tunnel.
+func LogPath(dir, id string) string {
+	return filepath.Join(dir, id+".log")
+}
+
+// Server answers requests on the control socket of a tunnel.
+type Server struct {
+	listener *net.UnixListener
+	log      string
+}
+
+// Listen registers the tunnel with the given id in the state directory by
+// creating its control socket.
+func Listen(id string) (*Server, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: SocketPath(dir, id), Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed by Close, not when Serve stops
+	listener.SetUnlinkOnClose(false)
+	return &Server{listener: listener, log: LogPath(dir, id)}, nil
+}
+
+// Close unregisters the tunnel by removing its control socket and log file.
+// Stop waits for the socket to be removed, so Close should only be called
+// once the tunnel has been torn down.
+func (s *Server) Close() error {
+	s.listener.Close()
+	os.Remove(s.log)
+	return os.Remove(s.listener.Addr().String())
+}
+
+// Serve answers requests on the control socket until Close is called. status
+// is called for every status request, stop when the tunnel is asked to shut
+// down.
+func (s *Server) Serve(status func() Tunnel, stop func()) error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			var req request
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				return
+			}
+
+			var resp response
+			switch req.Command {
+			case "status":
+				t := status()
+				resp.Tunnel = &t
+			case "stop":
+				t := status()
+				resp.Tunnel = &t
+				defer stop()
+			default:
+				resp.Error = "unknown command " + req.Command
+			}
+			json.NewEncoder(conn).Encode(resp)
+		}()
+	}
+}
+
+func call(sock, command string) (*Tunnel, error) {
+	conn, err := net.DialTimeout("unix", sock, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(request{Command: command}); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Tunnel, nil
+}
+
+// List returns the status of all tunnels running in the background. Sockets
+// of tunnels that are gone are cleaned up.
+func List() ([]Tunnel, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	socks, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels := make([]Tunnel, 0, len(socks))
+	for _, sock := range socks {
+		t, err := call(sock, "status")
+		if err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				os.Remove(sock)
+				os.Remove(strings.TrimSuffix(sock, ".sock") + ".log")
+			}
+			continue
+		}
+		tunnels = append(tunnels, *t)
+	}
+	return tunnels, nil
+}
+
+// Stop asks the tunnel with the given id to shut down and waits until it
+// has removed its control socket.
+func Stop(id string, timeout time.Duration) (*Tunnel, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	sock := SocketPath(dir, id)
+	t, err := call(sock, "stop")
+	if err != nil {
+		if _, statErr := os.Stat(sock); os.IsNotExist(statErr) {
+			return nil, fmt.Errorf("no tunnel with id %s", id)
+		}
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(sock); os.IsNotExist(err) {
+			return t, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return t, fmt.Errorf("tunnel %s did not stop within %s", id, timeout)
+}
@@ -0,0 +1,56 @@
+package control
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStopWaitsForClose(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	srv, err := Listen("1a2b3c4d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	closed := make(chan time.Time, 1)
+	go func() {
+		served <- srv.Serve(func() Tunnel { return Tunnel{ID: "1a2b3c4d", Server: "db01"} }, func() {
+			// the tunnel takes a while to tear down
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				closed <- time.Now()
+				srv.Close()
+			}()
+		})
+	}()
+
+	tunnels, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tunnels) != 1 || tunnels[0].Server != "db01" {
+		t.Fatalf("List = %+v, want db01", tunnels)
+	}
+
+	tun, err := Stop("1a2b3c4d", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := time.Now()
+	if tun.Server != "db01" {
+		t.Errorf("Stop = %+v, want db01", tun)
+	}
+	if c := <-closed; stopped.Before(c) {
+		t.Error("Stop returned before the tunnel was unregistered")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %s", err)
+	}
+
+	dir, _ := StateDir()
+	if _, err := os.Stat(SocketPath(dir, "1a2b3c4d")); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Package dns implements a minimal DNS server answering A queries for a
+// fixed set of names, enough to resolve the loopback addresses of VMs.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+const (
+	typeA     = 1
+	classIN   = 1
+	headerLen = 12
+	ttl       = 60
+
+	rcodeFormErr  = 1
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+)
+
+// LookupFunc returns the address of name, which is lower case and has no
+// trailing dot.
+type LookupFunc func(name string) (netip.Addr, bool)
+
+// Serve answers the queries received on conn until ctx is cancelled. Names
+// unknown to lookup are answered with NXDOMAIN.
+func Serve(ctx context.Context, conn net.PacketConn, lookup LookupFunc) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if resp := answer(buf[:n], lookup); resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// answer builds the response to the query in msg, nil if msg is not a query.
+func answer(msg []byte, lookup LookupFunc) []byte {
+	if len(msg) < headerLen || msg[2]&0x80 != 0 {
+		return nil
+	}
+
+	flags := uint16(0x8400) | uint16(msg[2]&0x01)<<8 // QR, AA, copy RD
+	if msg[2]&0x78 != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		// only standard queries with a single question are supported
+		return header(msg, flags|rcodeNotImp, 0, 0)
+	}
+
+	name, end, ok := parseName(msg, headerLen)
+	if !ok || end+4 > len(msg) {
+		return header(msg, flags|rcodeFormErr, 0, 0)
+	}
+	qtype := binary.BigEndian.Uint16(msg[end:])
+	qclass := binary.BigEndian.Uint16(msg[end+2:])
+	question := msg[headerLen : end+4]
+
+	addr, found := lookup(name)
+	if !found {
+		return append(header(msg, flags|rcodeNXDomain, 1, 0), question...)
+	}
+	if qtype != typeA || qclass != classIN {
+		// the name exists, but has no records of this type
+		return append(header(msg, flags, 1, 0), question...)
+	}
+
+	resp := append(header(msg, flags, 1, 1), question...)
+	resp = binary.BigEndian.AppendUint16(resp, 0xc000|headerLen) // pointer to the question name
+	resp = binary.BigEndian.AppendUint16(resp, typeA)
+	resp = binary.BigEndian.AppendUint16(resp, classIN)
+	resp = binary.BigEndian.AppendUint32(resp, ttl)
+	ip := addr.As4()
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(ip)))
+	return append(resp, ip[:]...)
+}
+
+func header(msg []byte, flags, qdcount, ancount uint16) []byte {
+	h := make([]byte, headerLen)
+	copy(h, msg[:2])
+	binary.BigEndian.PutUint16(h[2:], flags)
+	binary.BigEndian.PutUint16(h[4:], qdcount)
+	binary.BigEndian.PutUint16(h[6:], ancount)
+	return h
+}
+
+// parseName reads the uncompressed name starting at off and returns it
+// together with the offset following it.
+func parseName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 || off+l > len(msg) {
+			return "", 0, false
+		}
+		labels = append(labels, strings.ToLower(string(msg[off:off+l])))
+		off += l
+	}
+	return strings.Join(labels, "."), off, true
+}
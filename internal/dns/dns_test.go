@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// query builds a standard query for name with the given header flags.
+func query(id uint16, flags uint16, name string, qtype, qclass uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, l := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(l)))
+		msg = append(msg, l...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, qclass)
+}
+
+func TestAnswer(t *testing.T) {
+	hosts := map[string]netip.Addr{
+		"web01.demo.osssh": netip.MustParseAddr("127.77.3.4"),
+	}
+	lookup := func(name string) (netip.Addr, bool) {
+		addr, ok := hosts[name]
+		return addr, ok
+	}
+
+	const rd = 0x0100
+	webA := query(0xbeef, rd, "web01.demo.osssh", typeA, classIN)
+	tests := []struct {
+		name    string
+		msg     []byte
+		nilResp bool
+		flags   uint16
+		qdcount uint16
+		ancount uint16
+		addr    string
+	}{
+		{name: "a record", msg: webA, flags: 0x8500, qdcount: 1, ancount: 1, addr: "127.77.3.4"},
+		{name: "case insensitive", msg: query(0xbeef, 0, "WEB01.Demo.osssh", typeA, classIN), flags: 0x8400, qdcount: 1, ancount: 1, addr: "127.77.3.4"},
+		{name: "unknown name", msg: query(0xbeef, rd, "db01.demo.osssh", typeA, classIN), flags: 0x8500 | rcodeNXDomain, qdcount: 1},
+		{name: "aaaa of known name", msg: query(0xbeef, rd, "web01.demo.osssh", 28, classIN), flags: 0x8500, qdcount: 1},
+		{name: "other class", msg: query(0xbeef, 0, "web01.demo.osssh", typeA, 3), flags: 0x8400, qdcount: 1},
+		{name: "opcode not supported", msg: query(0xbeef, 0x2800, "web01.demo.osssh", typeA, classIN), flags: 0x8400 | rcodeNotImp},
+		{name: "two questions", msg: func() []byte {
+			m := bytes.Clone(webA)
+			binary.BigEndian.PutUint16(m[4:], 2)
+			return m
+		}(), flags: 0x8500 | rcodeNotImp},
+		{name: "truncated question", msg: webA[:len(webA)-3], flags: 0x8500 | rcodeFormErr},
+		{name: "truncated name", msg: webA[:headerLen+4], flags: 0x8500 | rcodeFormErr},
+		{name: "compressed name", msg: append(bytes.Clone(webA[:headerLen]), 0xc0, 0x0c, 0, 1, 0, 1), flags: 0x8500 | rcodeFormErr},
+		{name: "response", msg: query(0xbeef, 0x8000, "web01.demo.osssh", typeA, classIN), nilResp: true},
+		{name: "short", msg: webA[:headerLen-1], nilResp: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := answer(tt.msg, lookup)
+			if tt.nilResp {
+				if resp != nil {
+					t.Fatalf("answer = %x, want no response", resp)
+				}
+				return
+			}
+			if len(resp) < headerLen {
+				t.Fatalf("answer = %x, too short", resp)
+			}
+			if id := binary.BigEndian.Uint16(resp); id != 0xbeef {
+				t.Errorf("id = %#x, want 0xbeef", id)
+			}
+			if flags := binary.BigEndian.Uint16(resp[2:]); flags != tt.flags {
+				t.Errorf("flags = %#x, want %#x", flags, tt.flags)
+			}
+			if n := binary.BigEndian.Uint16(resp[4:]); n != tt.qdcount {
+				t.Errorf("qdcount = %d, want %d", n, tt.qdcount)
+			}
+			if n := binary.BigEndian.Uint16(resp[6:]); n != tt.ancount {
+				t.Errorf("ancount = %d, want %d", n, tt.ancount)
+			}
+
+			rest := resp[headerLen:]
+			if tt.qdcount == 1 {
+				question := tt.msg[headerLen:]
+				if !bytes.HasPrefix(rest, question) {
+					t.Fatalf("question = %x, want %x", rest, question)
+				}
+				rest = rest[len(question):]
+			}
+			if tt.ancount == 0 {
+				if len(rest) != 0 {
+					t.Errorf("unexpected records %x", rest)
+				}
+				return
+			}
+
+			ip := netip.MustParseAddr(tt.addr).As4()
+			want := []byte{0xc0, headerLen, 0, typeA, 0, classIN, 0, 0, 0, ttl, 0, 4}
+			want = append(want, ip[:]...)
+			if !bytes.Equal(rest, want) {
+				t.Errorf("answer record = %x, want %x", rest, want)
+			}
+		})
+	}
+}
@@ -3,45 +3,332 @@ package utils
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/hashicorp/go-uuid"
+	"github.com/modzilla99/osssh/internal/progress"
 	"github.com/modzilla99/osssh/internal/ssh"
 	"github.com/modzilla99/osssh/types/generic"
 	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
 )
 
 func ParseArgs() (args generic.Args) {
-	// args := generic.Args{}
+	fs := NewFlagSet("osssh", &args)
+	fs.IntVar(&args.Port, "p", 2222, "Port for SSH to locally listen on")
+	fs.IntVar(&args.RemotePort, "r", 22, "Remote port to forward traffic to")
+	fs.Var((*forwardFlag)(&args.Forwards), "L", "Forward [tcp|udp:]localport:remoteport, can be repeated (overrides -p and -r)")
+	MasterFlag(fs, &args)
+	ScopeFlags(fs, &args)
+	SearchFlags(fs, &args)
+	fs.BoolVar(&args.Lazy, "lazy", false, "only bind the local listeners, bring the tunnel up on the first connection")
+	fs.DurationVar(&args.IdleTimeout, "idle-timeout", 5*time.Minute, "tear a lazy tunnel down after no connection has been open for this long")
+	fs.BoolVar(&args.Background, "background", false, "run the tunnel in the background, see osssh ps and osssh stop")
+	fs.Var((*reverseFlag)(&args.Reverse), "R", "Reverse forward [bindaddress:]vmport:localhost:localport into the tenant network, can be repeated")
+	fs.Usage = func() {
+		fmt.Println("Usage: osssh [flags] server")
+		fmt.Println("       osssh <command> [flags] server [-- args]")
+		fmt.Println("\nCommands: ssh, exec, shell, cp, run, up, hosts, gateway, serve, login, projects, ps, stop, master")
+		fmt.Println("\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	extra := ParseSubcommand(fs, &args, os.Args[1:])
+	if len(extra) != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if len(args.Forwards) == 0 && len(args.Reverse) == 0 {
+		args.Forwards = []generic.Forward{{Type: "tcp", LocalPort: args.Port, RemotePort: args.RemotePort}}
+	}
+	return args
+}
+
+// NewFlagSet returns a flag set for a command with the flags shared by all
+// commands already registered.
+func NewFlagSet(name string, args *generic.Args) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
 	// Set username, default to the current username of the shell session
 	username, _ := os.LookupEnv("USER")
-	flag.StringVar(&args.Username, "u", username, "sets username to connect to HV with")
+	fs.StringVar(&args.Username, "u", username, "sets username to connect to HV with")
+	return fs
+}
 
-	flag.IntVar(&args.Port, "p", 2222, "Port for SSH to locally listen on")
-	flag.IntVar(&args.RemotePort, "r", 22, "Remote port to forward traffic to")
+// MasterFlag registers -master for commands opening a single tunnel.
+func MasterFlag(fs *flag.FlagSet, args *generic.Args) {
+	fs.BoolVar(&args.Master, "master", false, "share the connection to the hypervisor through a master process, started if none is running")
+}
+
+// ScopeFlags registers the flags selecting where servers are looked up.
+func ScopeFlags(fs *flag.FlagSet, args *generic.Args) {
+	fs.StringVar(&args.Project, "project", "", "scope to this project (name or id) instead of the one of the configuration")
+	fs.StringVar(&args.Cloud, "cloud", "", "use this cloud of clouds.yaml instead of OS_CLOUD")
+	fs.BoolVar(&args.AllProjects, "all-projects", false, "look the server up in all projects, requires admin")
+}
 
-	flag.Parse()
-	parsedArgs := flag.Args()
+// SearchFlags registers the flags searching a single server in several clouds
+// or regions, for the commands taking one.
+func SearchFlags(fs *flag.FlagSet, args *generic.Args) {
+	fs.BoolVar(&args.AllClouds, "all-clouds", false, "search the server in all clouds of clouds.yaml in parallel")
+	fs.BoolVar(&args.AllRegions, "all-regions", false, "search the server in all regions of the cloud in parallel")
+}
+
+// ParseSubcommand parses the flags of a command taking a server name or uuid,
+// optionally followed by -- and extra arguments which are returned.
+func ParseSubcommand(fs *flag.FlagSet, args *generic.Args, arguments []string) (extra []string) {
+	fs.Parse(arguments)
+	parsedArgs := fs.Args()
 
 	if args.Username == "" {
 		fmt.Println("Cannot get username from environment, please specify username with -u")
 		os.Exit(1)
 	}
 
-	if len(parsedArgs) != 1 {
-		fmt.Println("Usage: osssh [-u] uuid")
-		flag.PrintDefaults()
+	if len(parsedArgs) == 0 {
+		fs.Usage()
 		os.Exit(1)
 	}
+	args.Server = parsedArgs[0]
 
-	if _, err := uuid.ParseUUID(parsedArgs[0]); err != nil {
-		fmt.Println("Please specify a valid uuid for the server")
-		os.Exit(1)
+	// flags of osssh may also follow the server, everything from the first
+	// argument that isn't one of them is passed through, like ssh's -v
+	extra = parsedArgs[1:]
+	n := leadingFlags(fs, extra)
+	fs.Parse(extra[:n])
+	extra = extra[n:]
+	if len(extra) > 0 && extra[0] == "--" {
+		extra = extra[1:]
 	}
-	args.UUID = parsedArgs[0]
-	return args
+	return extra
+}
+
+// leadingFlags returns the number of arguments at the start of arguments that
+// are flags defined in fs, including their values.
+func leadingFlags(fs *flag.FlagSet, arguments []string) int {
+	i := 0
+	for i < len(arguments) {
+		arg := arguments[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		f := fs.Lookup(name)
+		if f == nil {
+			break
+		}
+		i++
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if !hasValue {
+			// the value is the next argument
+			i++
+		}
+	}
+	return min(i, len(arguments))
+}
+
+type forwardFlag []generic.Forward
+
+func (f *forwardFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*f))
+	for _, fw := range *f {
+		s = append(s, fw.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *forwardFlag) Set(value string) error {
+	fw, err := ParseForward(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fw)
+	return nil
+}
+
+// ParseForward parses a forward specification in the form of
+// [tcp|udp:]localport:remoteport.
+func ParseForward(value string) (generic.Forward, error) {
+	fw := generic.Forward{Type: "tcp"}
+
+	parts := strings.Split(value, ":")
+	switch len(parts) {
+	case 2:
+	case 3:
+		fw.Type = strings.ToLower(parts[0])
+		parts = parts[1:]
+	default:
+		return fw, fmt.Errorf("invalid forward %q, expected [tcp|udp:]localport:remoteport", value)
+	}
+
+	if fw.Type != "tcp" && fw.Type != "udp" {
+		return fw, fmt.Errorf("invalid protocol %q, only tcp and udp are supported", fw.Type)
+	}
+
+	var err error
+	if fw.LocalPort, err = parsePort(parts[0]); err != nil {
+		return fw, err
+	}
+	if fw.RemotePort, err = parsePort(parts[1]); err != nil {
+		return fw, err
+	}
+	return fw, nil
+}
+
+type reverseFlag []generic.ReverseForward
+
+func (f *reverseFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*f))
+	for _, r := range *f {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *reverseFlag) Set(value string) error {
+	r, err := ParseReverseForward(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// DefaultReverseBindAddress is the address inside the network namespace
+// reverse forwards listen on. It is the metadata IP every VM can reach.
+const DefaultReverseBindAddress = "169.254.169.254"
+
+// ParseReverseForward parses a reverse forward specification in the form of
+// [bindaddress:]vmport:localhost:localport.
+func ParseReverseForward(value string) (generic.ReverseForward, error) {
+	r := generic.ReverseForward{BindAddress: DefaultReverseBindAddress}
+
+	parts := strings.Split(value, ":")
+	switch len(parts) {
+	case 3:
+	case 4:
+		r.BindAddress = parts[0]
+		parts = parts[1:]
+	default:
+		return r, fmt.Errorf("invalid reverse forward %q, expected [bindaddress:]vmport:localhost:localport", value)
+	}
+
+	var err error
+	if r.RemotePort, err = parsePort(parts[0]); err != nil {
+		return r, err
+	}
+	r.LocalHost = parts[1]
+	if r.LocalPort, err = parsePort(parts[2]); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// LoadTunnelsFile reads and validates a tunnels file for osssh up.
+func LoadTunnelsFile(file string) (*generic.TunnelsFile, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tf generic.TunnelsFile
+	if err := yaml.Unmarshal(content, &tf); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	if len(tf.Tunnels) == 0 {
+		return nil, fmt.Errorf("no tunnels defined in %s", file)
+	}
+
+	for i, t := range tf.Tunnels {
+		if t.Server == "" {
+			return nil, fmt.Errorf("tunnel %d: server is required", i+1)
+		}
+		if _, err := TunnelForward(t); err != nil {
+			return nil, fmt.Errorf("tunnel %d (%s): %w", i+1, t.Server, err)
+		}
+	}
+	return &tf, nil
+}
+
+// LoadServeConfig reads and validates the configuration of osssh serve.
+func LoadServeConfig(file string) (*generic.ServeConfig, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var c generic.ServeConfig
+	if err := yaml.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	if c.Listen == "" {
+		c.Listen = "0.0.0.0:2222"
+	}
+	if c.HostKey == "" {
+		return nil, fmt.Errorf("host_key is required in %s", file)
+	}
+	if len(c.Users) == 0 {
+		return nil, fmt.Errorf("no users defined in %s", file)
+	}
+
+	for i, u := range c.Users {
+		if u.Name == "" || u.Cloud == "" {
+			return nil, fmt.Errorf("user %d: name and cloud are required", i+1)
+		}
+		for _, k := range u.Keys {
+			if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(k)); err != nil {
+				return nil, fmt.Errorf("user %s: invalid key: %w", u.Name, err)
+			}
+		}
+	}
+	return &c, nil
+}
+
+// TunnelForward returns the forward described by the tunnel spec.
+func TunnelForward(t generic.TunnelSpec) (generic.Forward, error) {
+	fw := generic.Forward{Type: strings.ToLower(t.Protocol), BindAddress: "127.0.0.1"}
+	if fw.Type == "" {
+		fw.Type = "tcp"
+	}
+	if fw.Type != "tcp" && fw.Type != "udp" {
+		return fw, fmt.Errorf("invalid protocol %q, only tcp and udp are supported", fw.Type)
+	}
+
+	local := t.Local
+	if host, port, err := net.SplitHostPort(local); err == nil {
+		fw.BindAddress = host
+		local = port
+	}
+
+	var err error
+	if fw.LocalPort, err = parsePort(local); err != nil {
+		return fw, err
+	}
+	if fw.RemotePort, err = parsePort(strconv.Itoa(t.Remote)); err != nil {
+		return fw, err
+	}
+	return fw, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
 }
 
 func bashGetHaProxyPid(net string) string {
@@ -61,13 +348,13 @@ func GetNetNSFromNeutronMetadata(c *gossh.Client, net string) (string, error) {
 	if strings.Contains(net, "'") || strings.HasSuffix(net, `\`) {
 		return "", fmt.Errorf("invalid network id: %s", net)
 	}
-	fmt.Print("Obtaining path to NetworkNamespace...")
+	progress.Print("Obtaining path to NetworkNamespace...")
 
 	out, stderr, err := ssh.RunCommand(c, bashGetHaProxyPid(net))
 	if err != nil {
 		return "", fmt.Errorf("unable to get pid of haproxy: stderr: %s error: %w", stderr, err)
 	}
 
-	fmt.Println("Done")
+	progress.Println("Done")
 	return path.Join("/proc", out, "/ns/net"), nil
 }
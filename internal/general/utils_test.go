@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    generic.Forward
+		wantErr bool
+	}{
+		{value: "8080:80", want: generic.Forward{Type: "tcp", LocalPort: 8080, RemotePort: 80}},
+		{value: "tcp:8080:80", want: generic.Forward{Type: "tcp", LocalPort: 8080, RemotePort: 80}},
+		{value: "udp:5353:53", want: generic.Forward{Type: "udp", LocalPort: 5353, RemotePort: 53}},
+		{value: "UDP:5353:53", want: generic.Forward{Type: "udp", LocalPort: 5353, RemotePort: 53}},
+		{value: "1:65535", want: generic.Forward{Type: "tcp", LocalPort: 1, RemotePort: 65535}},
+		{value: "sctp:8080:80", wantErr: true},
+		{value: ":8080:80", wantErr: true},
+		{value: "8080", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "0:80", wantErr: true},
+		{value: "8080:65536", wantErr: true},
+		{value: "-1:80", wantErr: true},
+		{value: "http:80", wantErr: true},
+		{value: "8080:80:", wantErr: true},
+		// forwards take ports only, addresses are not accepted
+		{value: "::1:8080:80", wantErr: true},
+		{value: "[::1]:8080:80", wantErr: true},
+		{value: "tcp:[::1]:8080:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseForward(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseForward(%q) = %+v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseForward(%q): %s", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseForward(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardLocalAddress(t *testing.T) {
+	tests := []struct {
+		fw   generic.Forward
+		want string
+	}{
+		{fw: generic.Forward{LocalPort: 8080}, want: "127.0.0.1:8080"},
+		{fw: generic.Forward{BindAddress: "127.77.0.5", LocalPort: 22}, want: "127.77.0.5:22"},
+		{fw: generic.Forward{BindAddress: "::1", LocalPort: 8080}, want: "[::1]:8080"},
+	}
+	for _, tt := range tests {
+		if got := tt.fw.LocalAddress(); got != tt.want {
+			t.Errorf("%+v.LocalAddress() = %s, want %s", tt.fw, got, tt.want)
+		}
+	}
+}
+
+func TestParseSubcommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments []string
+		wantUser  string
+		wantExtra []string
+	}{
+		{name: "server only", arguments: []string{"vm"}, wantUser: "alice"},
+		{name: "flags before server", arguments: []string{"-u", "bob", "vm"}, wantUser: "bob"},
+		{name: "flags after server", arguments: []string{"vm", "-u", "bob"}, wantUser: "bob"},
+		{name: "flag with value after server", arguments: []string{"vm", "-u=bob"}, wantUser: "bob"},
+		{name: "passthrough flag", arguments: []string{"vm", "-v"}, wantUser: "alice", wantExtra: []string{"-v"}},
+		{name: "passthrough flags with value", arguments: []string{"vm", "-i", "key", "-p", "22"}, wantUser: "alice", wantExtra: []string{"-i", "key", "-p", "22"}},
+		{name: "own flag then passthrough", arguments: []string{"vm", "-u", "bob", "-v", "-u", "carol"}, wantUser: "bob", wantExtra: []string{"-v", "-u", "carol"}},
+		{name: "double dash", arguments: []string{"vm", "--", "-u", "bob"}, wantUser: "alice", wantExtra: []string{"-u", "bob"}},
+		{name: "own flag then double dash", arguments: []string{"vm", "-master", "--", "uptime"}, wantUser: "alice", wantExtra: []string{"uptime"}},
+		{name: "command", arguments: []string{"vm", "uptime", "-p"}, wantUser: "alice", wantExtra: []string{"uptime", "-p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("USER", "alice")
+			var args generic.Args
+			fs := NewFlagSet("test", &args)
+			MasterFlag(fs, &args)
+
+			extra := ParseSubcommand(fs, &args, tt.arguments)
+			if args.Server != "vm" {
+				t.Errorf("server = %q, want vm", args.Server)
+			}
+			if args.Username != tt.wantUser {
+				t.Errorf("username = %q, want %q", args.Username, tt.wantUser)
+			}
+			if !slices.Equal(extra, tt.wantExtra) {
+				t.Errorf("extra = %q, want %q", extra, tt.wantExtra)
+			}
+		})
+	}
+}
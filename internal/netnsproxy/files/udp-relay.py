@@ -0,0 +1,66 @@
+# Relays framed datagrams between the SSH channel (stdin/stdout) and the
+# netns-proxy listening on 127.0.0.1:<port>. Every frame is prefixed with the
+# id of the local client flow (uint32) and the payload length (uint16).
+import os
+import select
+import socket
+import struct
+import sys
+import time
+
+HEADER = struct.Struct("!IH")
+IDLE_TIMEOUT = 60
+
+port = int(sys.argv[1])
+flows = {}
+buf = b""
+
+
+def send(data):
+    while data:
+        n = os.write(1, data)
+        data = data[n:]
+
+
+def expire():
+    now = time.monotonic()
+    for flow, (sock, last) in list(flows.items()):
+        if now - last > IDLE_TIMEOUT:
+            sock.close()
+            del flows[flow]
+
+
+while True:
+    socks = {sock: flow for flow, (sock, _) in flows.items()}
+    readable, _, _ = select.select([0] + list(socks), [], [], 5)
+    for r in readable:
+        if r == 0:
+            data = os.read(0, 65536)
+            if not data:
+                sys.exit(0)
+            buf += data
+            while len(buf) >= HEADER.size:
+                flow, length = HEADER.unpack_from(buf)
+                if len(buf) < HEADER.size + length:
+                    break
+                payload = buf[HEADER.size:HEADER.size + length]
+                buf = buf[HEADER.size + length:]
+                if flow not in flows:
+                    sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+                    sock.connect(("127.0.0.1", port))
+                    flows[flow] = (sock, 0)
+                sock = flows[flow][0]
+                flows[flow] = (sock, time.monotonic())
+                try:
+                    sock.send(payload)
+                except OSError:
+                    pass
+        else:
+            flow = socks[r]
+            try:
+                payload = r.recv(65535)
+            except OSError:
+                continue
+            flows[flow] = (r, time.monotonic())
+            send(HEADER.pack(flow, len(payload)) + payload)
+    expire()
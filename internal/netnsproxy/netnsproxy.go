@@ -3,9 +3,12 @@ package netnsproxy
 import (
 	"context"
 	"embed"
+	"encoding/base64"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/modzilla99/osssh/internal/progress"
 	"github.com/modzilla99/osssh/internal/ssh"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -16,11 +19,11 @@ var (
 )
 
 func Setup(c *gossh.Client) error {
-	fmt.Print("Uploading netns-proxy...")
+	progress.Print("Uploading netns-proxy...")
 	file, _ := GetNetnsProxyFileBytes(true)
 	_, _, err := ssh.RunCommand(c, "test -e /tmp/netns-proxy")
 	if err == nil {
-		fmt.Println("Ok")
+		progress.Println("Ok")
 		return nil
 	}
 
@@ -34,7 +37,7 @@ func Setup(c *gossh.Client) error {
 		return fmt.Errorf("cannot set permissions of netnsproxy: %w", err)
 	}
 
-	fmt.Println("Done")
+	progress.Println("Done")
 	return nil
 }
 
@@ -49,11 +52,17 @@ func GetNetnsProxyFileBytes(old bool) ([]byte, error) {
 	return files.ReadFile(fileName)
 }
 
+// NetnsProxyOpts configures a netns-proxy. By default it listens on the
+// hypervisor and connects to Address:ProxyPort inside the namespace at Path.
+// With Reverse set it listens on Address:ListenPort inside the namespace and
+// connects to 127.0.0.1:ProxyPort on the hypervisor instead.
 type NetnsProxyOpts struct {
 	Path       string
 	Address    string
+	Protocol   string
 	ListenPort int
 	ProxyPort  int
+	Reverse    bool
 }
 
 const bashWrapper = `run_me() {
@@ -67,9 +76,20 @@ wait $PID
 `
 
 func (o NetnsProxyOpts) Command() string {
+	protocol := o.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
 	exec := fmt.Sprintf("/usr/bin/sudo /tmp/netns-proxy -b 127.0.0.1:%d -p %s %s %s:%d",
-		o.ListenPort, "tcp", o.Path, o.Address, o.ProxyPort,
+		o.ListenPort, protocol, o.Path, o.Address, o.ProxyPort,
 	)
+	if o.Reverse {
+		// enter the tenant namespace to listen there and connect back to the
+		// namespace of the hypervisor
+		exec = fmt.Sprintf("/usr/bin/sudo nsenter --net=%s /tmp/netns-proxy -b %s:%d -p %s /proc/1/ns/net 127.0.0.1:%d",
+			o.Path, o.Address, o.ListenPort, protocol, o.ProxyPort,
+		)
+	}
 	return fmt.Sprintf(bashWrapper, exec)
 }
 
@@ -100,7 +120,7 @@ func RunNetnsProxy(ctx context.Context, client *gossh.Client, opts NetnsProxyOpt
 		return fmt.Errorf("netnsproxy exited unexpectedly")
 
 	case <-ctx.Done():
-		fmt.Print("Shutting down remote netnsproxy...")
+		progress.Print("Shutting down remote netnsproxy...")
 
 		err = sess.Signal(gossh.SIGINT)
 		if err != nil {
@@ -116,26 +136,43 @@ func RunNetnsProxy(ctx context.Context, client *gossh.Client, opts NetnsProxyOpt
 				switch t := err.(type) {
 				case *gossh.ExitError:
 					if t.ExitStatus() == 130 {
-						fmt.Println("Done")
+						progress.Println("Done")
 						return nil
 					}
-					fmt.Println("Error")
+					progress.Println("Error")
 					return fmt.Errorf("netnsproxy exited with unexpected code")
 				default:
-					fmt.Println("Error")
+					progress.Println("Error")
 				}
 				return fmt.Errorf("error waiting for process to finish: %w", err)
 			}
 		case <-timeout.C:
-			fmt.Println("Error")
+			progress.Println("Error")
 			return fmt.Errorf("timeout reached stopping netnsproxy")
 		}
 	}
 	return nil
 }
 
-func checkPortAvailability(c *gossh.Client, port int) (bool, error) {
-	_, _, err := ssh.RunCommand(c, fmt.Sprintf("nc -z 127.0.0.1 %d", port))
+// UDPRelayCommand returns the command that relays framed datagrams from the
+// SSH channel to the udp netns-proxy listening on the given port.
+func UDPRelayCommand(port int) (string, error) {
+	script, err := files.ReadFile("files/udp-relay.py")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`python3 -c "$(printf %%s %s | base64 -d)" %d`,
+		base64.StdEncoding.EncodeToString(script), port,
+	), nil
+}
+
+func checkPortAvailability(c *gossh.Client, protocol string, port int) (bool, error) {
+	cmd := fmt.Sprintf("nc -z 127.0.0.1 %d", port)
+	if protocol == "udp" {
+		// nc cannot reliably probe udp ports, check for a bound socket instead
+		cmd = fmt.Sprintf("ss -Hlun 'sport = :%d' | grep -q .", port)
+	}
+	_, _, err := ssh.RunCommand(c, cmd)
 	if err != nil {
 		switch err := err.(type) {
 		case *gossh.ExitError:
@@ -150,7 +187,9 @@ func checkPortAvailability(c *gossh.Client, port int) (bool, error) {
 	return false, nil
 }
 
-func GetAvailablePort(c *gossh.Client) (proxyPort int, err error) {
+// GetAvailablePort finds a free port on the hypervisor for the given protocol,
+// skipping the ports in used that have been handed out but are not bound yet.
+func GetAvailablePort(c *gossh.Client, protocol string, used ...int) (proxyPort int, err error) {
 	var portOk bool
 	const (
 		proxyPortStart = 3021 // + 1
@@ -166,7 +205,11 @@ func GetAvailablePort(c *gossh.Client) (proxyPort int, err error) {
 			break
 		}
 
-		portOk, err = checkPortAvailability(c, proxyPort)
+		if slices.Contains(used, proxyPort) {
+			continue
+		}
+
+		portOk, err = checkPortAvailability(c, protocol, proxyPort)
 		if err != nil {
 			err = fmt.Errorf("unable to check for available ports: %w\n", err)
 			break
@@ -3,17 +3,15 @@ package auth
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
-	"path"
+	"strconv"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
 	"github.com/gophercloud/gophercloud/v2/openstack/config/clouds"
-	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
 	"github.com/modzilla99/osssh/internal/openstack/oidc"
-	gophercloudOidc "github.com/modzilla99/osssh/internal/openstack/oidc"
-	"gopkg.in/yaml.v3"
 )
 
 type AuthOptions struct {
@@ -21,53 +19,119 @@ type AuthOptions struct {
 	AuthType         clouds.AuthType
 	AuthOptions      *gophercloud.AuthOptions
 	IdentityProvider string
+	// Cloud is the name of the selected cloud, tokens of interactive logins
+	// are stored per cloud
+	Cloud string
+	OIDC  oidc.ClientConfig
+	// Username and Password are the credentials of the v3oidcpassword grant
+	Username string
+	Password string
+	// HTTPClient trusts the CA certificate and presents the client
+	// certificate of the cloud
+	HTTPClient *http.Client
+	// Region and Availability select the endpoints of the service catalog
+	Region       string
+	Availability gophercloud.Availability
+
+	cloud *Cloud
 }
 
 func NewAuthOptions(o *clientconfig.ClientOpts) (*AuthOptions, error) {
-	ao, err := clientconfig.AuthOptions(o)
-	if err != nil {
-		return nil, err
+	// Parse clouds.yaml if a cloud is selected explicitly or by environment variable
+	cloud := &Cloud{}
+	c := o.Cloud
+	if c == "" {
+		c = os.Getenv("OS_CLOUD")
 	}
-
-	// Parse clouds.yaml if environment variable is provided
-	var cloud *Cloud
-	if c := os.Getenv("OS_CLOUD"); c != "" {
-		cloud, err = getAuthCloud(c)
+	if c != "" {
+		var (
+			entry *clientconfig.Cloud
+			err   error
+		)
+		cloud, entry, err = loadCloud(c)
 		if err != nil {
 			return nil, err
 		}
+		// gophercloud gets the merged entry instead of reading the files again
+		o.Cloud = c
+		o.YAMLOpts = cloudYAML{name: c, cloud: *entry}
 	}
 
-	// Set authentication params from clouds.yaml and Environment
-	var (
-		authType                      clouds.AuthType
-		identityProvider, accessToken string
-	)
-	if cloud != nil {
-		authType = cloud.AuthType
-		identityProvider = cloud.IdentityProvider
-		accessToken = cloud.AccessToken
+	region := firstOf(o.RegionName, os.Getenv("OS_REGION_NAME"), cloud.RegionName)
+	o.RegionName = region
+	o.EndpointType = firstOf(o.EndpointType, os.Getenv("OS_INTERFACE"),
+		os.Getenv("OS_ENDPOINT_TYPE"), cloud.Interface, cloud.EndpointType)
+	ao, err := clientconfig.AuthOptions(o)
+	if err != nil {
+		return nil, err
 	}
 
-	authTypeEnv := os.Getenv(`OS_AUTH_TYPE`)
-	identityProviderEnv := os.Getenv(`OS_IDENTITY_PROVIDER`)
-	accessTokenEnv := os.Getenv(`OS_ACCESS_TOKEN`)
+	httpClient, err := newHTTPClient(cloud)
+	if err != nil {
+		return nil, err
+	}
 
 	// Enviroment variables override config options from file
-	if authTypeEnv != "" {
-		authType = clouds.AuthType(authTypeEnv)
-	}
-	if identityProviderEnv != "" {
-		identityProvider = identityProviderEnv
+	redirectPort := cloud.RedirectPort
+	if v := os.Getenv("OS_REDIRECT_PORT"); v != "" {
+		redirectPort, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OS_REDIRECT_PORT: %w", err)
+		}
 	}
-	if accessTokenEnv != "" {
-		accessToken = accessTokenEnv
+	return &AuthOptions{
+		AccessToken:      fromEnv("OS_ACCESS_TOKEN", cloud.AccessToken),
+		AuthOptions:      ao,
+		AuthType:         clouds.AuthType(fromEnv("OS_AUTH_TYPE", string(cloud.AuthType))),
+		IdentityProvider: fromEnv("OS_IDENTITY_PROVIDER", cloud.IdentityProvider),
+		Cloud:            c,
+		Username:         fromEnv("OS_USERNAME", firstOf(cloud.Username, ao.Username)),
+		Password:         fromEnv("OS_PASSWORD", firstOf(cloud.Password, ao.Password)),
+		HTTPClient:       httpClient,
+		Region:           region,
+		Availability:     clientconfig.GetEndpointType(o.EndpointType),
+		OIDC: oidc.ClientConfig{
+			DiscoveryEndpoint: fromEnv("OS_DISCOVERY_ENDPOINT", cloud.DiscoveryEndpoint),
+			TokenEndpoint:     fromEnv("OS_ACCESS_TOKEN_ENDPOINT", cloud.AccessTokenEndpoint),
+			ClientID:          fromEnv("OS_CLIENT_ID", cloud.ClientID),
+			ClientSecret:      fromEnv("OS_CLIENT_SECRET", cloud.ClientSecret),
+			Scope:             fromEnv("OS_OPENID_SCOPE", cloud.OpenIDScope),
+			RedirectURI:       fromEnv("OS_REDIRECT_URI", cloud.RedirectURI),
+			RedirectPort:      redirectPort,
+			HTTPClient:        httpClient,
+		},
+		cloud: cloud,
+	}, nil
+}
+
+// fromEnv returns the value of the environment variable, or value if it is
+// not set.
+func fromEnv(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
+	return value
+}
 
-	return &AuthOptions{AccessToken: accessToken, AuthOptions: ao, AuthType: authType, IdentityProvider: identityProvider}, nil
+// firstOf returns the first of values that is set.
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
-const Authv3OidcAccessToken clouds.AuthType = "v3oidcaccesstoken"
+const (
+	Authv3OidcAccessToken clouds.AuthType = "v3oidcaccesstoken"
+	Authv3OidcDeviceAuthz clouds.AuthType = "v3oidcdeviceauthz"
+	Authv3OidcAuthCode    clouds.AuthType = "v3oidcauthcode"
+	// Authv3OidcPassword and Authv3OidcClientCredentials are for automation,
+	// they don't need a browser
+	Authv3OidcPassword          clouds.AuthType = "v3oidcpassword"
+	Authv3OidcClientCredentials clouds.AuthType = "v3oidcclientcredentials"
+)
 
 func Authenticate(ctx context.Context, o *clientconfig.ClientOpts) (provider *gophercloud.ProviderClient, err error) {
 	ao, err := NewAuthOptions(o)
@@ -75,71 +139,68 @@ func Authenticate(ctx context.Context, o *clientconfig.ClientOpts) (provider *go
 		return nil, err
 	}
 
-	if ao.AuthType == Authv3OidcAccessToken {
-		return oidc.AuthenticatedClient(ctx, &gophercloudOidc.AuthOptions{
-			AccessToken:      ao.AccessToken,
-			IdentityProvider: ao.IdentityProvider,
-			IdentityEndpoint: ao.AuthOptions.IdentityEndpoint,
-			DomainID:         ao.AuthOptions.DomainID,
-			DomainName:       ao.AuthOptions.DomainName,
-			Scope:            tokens.Scope(*ao.AuthOptions.Scope),
-		})
+	switch ao.AuthType {
+	case Authv3OidcAccessToken, Authv3OidcDeviceAuthz, Authv3OidcAuthCode, Authv3OidcPassword, Authv3OidcClientCredentials:
+		provider, err = authenticateOIDC(ctx, ao)
+		if err != nil {
+			return nil, err
+		}
+		setEndpointDefaults(provider, ao)
+		return provider, nil
 	}
 
-	provider, err = openstack.AuthenticatedClient(ctx, *ao.AuthOptions)
+	provider, err = openstack.NewClient(ao.AuthOptions.IdentityEndpoint)
 	if err != nil {
 		return nil, err
 	}
+	provider.HTTPClient = *ao.HTTPClient
 
-	return provider, nil
-}
+	// tunnels can run for days, longer than the token is valid
+	ao.AuthOptions.AllowReauth = true
+	err = openstack.Authenticate(ctx, provider, *ao.AuthOptions)
+	if err != nil {
+		return nil, err
+	}
 
-type Cloud struct {
-	AccessToken      string          `yaml:"access_token,omitempty" json:"access_token,omitempty"`
-	AuthType         clouds.AuthType `yaml:"auth_type,omitempty" json:"auth_type,omitempty"`
-	IdentityProvider string          `yaml:"identity_provider,omitempty" json:"identity_provider,omitempty"`
+	setEndpointDefaults(provider, ao)
+	return provider, nil
 }
 
-func getAuthCloud(cloud string) (authType *Cloud, err error) {
-	const (
-		currentDir = "./clouds.yaml"
-		userPath   = ".config/openstack/clouds.yaml"
-		systemPath = "/etc/openstack/clouds.yaml"
-	)
-	f, err := os.ReadFile(currentDir)
-	if err != nil && !os.IsNotExist(err) {
-		return
-	}
-	if err != nil && os.IsNotExist(err) {
-		var homeDir string
-		homeDir, err = os.UserHomeDir()
-		f, err = os.ReadFile(path.Join(homeDir, userPath))
-		if err != nil && !os.IsNotExist(err) {
-			return
+// setEndpointDefaults makes the endpoint locator of the provider select the
+// region and interface of the cloud, unless the caller asks for others.
+func setEndpointDefaults(provider *gophercloud.ProviderClient, ao *AuthOptions) {
+	locate := provider.EndpointLocator
+	provider.EndpointLocator = func(opts gophercloud.EndpointOpts) (string, error) {
+		if opts.Region == "" {
+			opts.Region = ao.Region
 		}
-		if err != nil && os.IsNotExist(err) {
-			f, err = os.ReadFile(systemPath)
-			if err != nil && !os.IsNotExist(err) {
-				return
-			}
-			if err != nil && os.IsNotExist(err) {
-				return nil, fmt.Errorf("Could not find clouds.yaml")
-			}
+		if opts.Availability == "" {
+			opts.Availability = ao.Availability
 		}
+		return locate(opts)
 	}
+}
 
-	type Clouds struct {
-		Clouds map[string]Cloud `yaml:"clouds" json:"clouds"`
-	}
-
-	var clouds Clouds
-	if err := yaml.Unmarshal(f, &clouds); err != nil {
-		return nil, err
-	}
-	c, ok := clouds.Clouds[cloud]
-	if !ok {
-		return nil, fmt.Errorf("Could not find cloud %s, in clouds.yaml", cloud)
-	}
-
-	return &c, nil
+type Cloud struct {
+	AccessToken       string          `yaml:"access_token,omitempty" json:"access_token,omitempty"`
+	AuthType          clouds.AuthType `yaml:"auth_type,omitempty" json:"auth_type,omitempty"`
+	IdentityProvider  string          `yaml:"identity_provider,omitempty" json:"identity_provider,omitempty"`
+	DiscoveryEndpoint string          `yaml:"discovery_endpoint,omitempty" json:"discovery_endpoint,omitempty"`
+	ClientID          string          `yaml:"client_id,omitempty" json:"client_id,omitempty"`
+	ClientSecret      string          `yaml:"client_secret,omitempty" json:"client_secret,omitempty"`
+	OpenIDScope       string          `yaml:"openid_scope,omitempty" json:"openid_scope,omitempty"`
+	RedirectURI       string          `yaml:"redirect_uri,omitempty" json:"redirect_uri,omitempty"`
+	RedirectPort      int             `yaml:"redirect_port,omitempty" json:"redirect_port,omitempty"`
+	RegionName        string          `yaml:"region_name,omitempty" json:"region_name,omitempty"`
+	Interface         string          `yaml:"interface,omitempty" json:"interface,omitempty"`
+	EndpointType      string          `yaml:"endpoint_type,omitempty" json:"endpoint_type,omitempty"`
+	CACertFile        string          `yaml:"cacert,omitempty" json:"cacert,omitempty"`
+	ClientCertFile    string          `yaml:"cert,omitempty" json:"cert,omitempty"`
+	ClientKeyFile     string          `yaml:"key,omitempty" json:"key,omitempty"`
+	Verify            *bool           `yaml:"verify,omitempty" json:"verify,omitempty"`
+	// AccessTokenEndpoint can be set instead of DiscoveryEndpoint for the
+	// password and client credentials grants
+	AccessTokenEndpoint string `yaml:"access_token_endpoint,omitempty" json:"access_token_endpoint,omitempty"`
+	Username            string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password            string `yaml:"password,omitempty" json:"password,omitempty"`
 }
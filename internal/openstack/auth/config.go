@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// loadCloud reads the entry of the cloud like the OpenStack client: from the
+// clouds.yaml in OS_CLIENT_CONFIG_FILE, the current directory,
+// ~/.config/openstack or /etc/openstack, merged with its profile from
+// clouds-public.yaml and the secrets from secure.yaml. The entry is returned
+// both as osssh's Cloud, which also takes the options of the auth section,
+// and as gophercloud's Cloud.
+func loadCloud(name string) (*Cloud, *clientconfig.Cloud, error) {
+	clouds, err := readClouds(clientconfig.FindAndReadCloudsYAML)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load clouds.yaml: %w", err)
+	}
+	secure, err := readClouds(clientconfig.FindAndReadSecureCloudsYAML)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load secure.yaml: %w", err)
+	}
+
+	entry, ok := clouds[name]
+	secureEntry, secureOk := secure[name]
+	if !ok && !secureOk {
+		return nil, nil, fmt.Errorf("Could not find cloud %s, in clouds.yaml", name)
+	}
+
+	profile, _ := entry["profile"].(string)
+	if profile == "" {
+		profile, _ = entry["cloud"].(string)
+	}
+	if profile != "" {
+		public, err := readCloudsKey(clientconfig.FindAndReadPublicCloudsYAML, "public-clouds")
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load clouds-public.yaml: %w", err)
+		}
+		p, ok := public[profile]
+		if !ok {
+			return nil, nil, fmt.Errorf("Could not find profile %s of cloud %s, in clouds-public.yaml", profile, name)
+		}
+		entry = mergeMaps(entry, p)
+	}
+	entry = mergeMaps(secureEntry, entry)
+
+	var gc clientconfig.Cloud
+	if err := convert(entry, &gc); err != nil {
+		return nil, nil, fmt.Errorf("invalid entry of cloud %s: %w", name, err)
+	}
+	// the profile is merged already
+	gc.Profile, gc.Cloud = "", ""
+
+	// keystoneauth expects the options of the OIDC plugins in the auth
+	// section, osssh also reads them next to auth_type
+	flat := map[string]any{}
+	if auth, ok := entry["auth"].(map[string]any); ok {
+		for k, v := range auth {
+			flat[k] = v
+		}
+	}
+	for k, v := range entry {
+		flat[k] = v
+	}
+	var c Cloud
+	if err := convert(flat, &c); err != nil {
+		return nil, nil, fmt.Errorf("invalid entry of cloud %s: %w", name, err)
+	}
+	return &c, &gc, nil
+}
+
+// readClouds reads the clouds of a file found by find. A missing file has no
+// clouds.
+func readClouds(find func() (string, []byte, error)) (map[string]map[string]any, error) {
+	return readCloudsKey(find, "clouds")
+}
+
+// readCloudsKey is like readClouds for files keeping the clouds in key, like
+// public-clouds in clouds-public.yaml.
+func readCloudsKey(find func() (string, []byte, error), key string) (map[string]map[string]any, error) {
+	_, content, err := find()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// other top-level keys may be of any type
+	var f map[string]yaml.Node
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, err
+	}
+	node, ok := f[key]
+	if !ok {
+		return nil, nil
+	}
+	var clouds map[string]map[string]any
+	if err := node.Decode(&clouds); err != nil {
+		return nil, err
+	}
+	return clouds, nil
+}
+
+// mergeMaps merges base into override recursively, values of override take
+// precedence.
+func mergeMaps(override, base map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		o, ok1 := v.(map[string]any)
+		b, ok2 := merged[k].(map[string]any)
+		if ok1 && ok2 {
+			merged[k] = mergeMaps(o, b)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func convert(in map[string]any, out any) error {
+	content, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(content, out)
+}
+
+// cloudYAML passes a cloud loaded by loadCloud to gophercloud.
+type cloudYAML struct {
+	name  string
+	cloud clientconfig.Cloud
+}
+
+func (c cloudYAML) LoadCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return map[string]clientconfig.Cloud{c.name: c.cloud}, nil
+}
+
+func (c cloudYAML) LoadSecureCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return nil, nil
+}
+
+func (c cloudYAML) LoadPublicCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return nil, nil
+}
+
+// newHTTPClient returns the client for the requests to the cloud and its
+// identity provider, with the TLS options of the cloud. OS_CACERT, OS_CERT,
+// OS_KEY and OS_INSECURE override them.
+func newHTTPClient(cloud *Cloud) (*http.Client, error) {
+	config := &tls.Config{}
+
+	if v := os.Getenv("OS_INSECURE"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OS_INSECURE: %w", err)
+		}
+		config.InsecureSkipVerify = insecure
+	} else if cloud.Verify != nil {
+		config.InsecureSkipVerify = !*cloud.Verify
+	}
+
+	if caCert := fromEnv("OS_CACERT", cloud.CACertFile); caCert != "" {
+		content, err := os.ReadFile(expandHome(caCert))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cacert: %w", err)
+		}
+		// the identity provider may use a public CA
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+
+	cert, key := fromEnv("OS_CERT", cloud.ClientCertFile), fromEnv("OS_KEY", cloud.ClientKeyFile)
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("cert and key must be set together")
+		}
+		pair, err := tls.LoadX509KeyPair(expandHome(cert), expandHome(key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// AppCredentialCloud returns a clouds.yaml entry authenticating with the
+// application credential to the cloud of o, with its region and TLS options.
+func AppCredentialCloud(o *clientconfig.ClientOpts, id, secret string) (map[string]any, error) {
+	ao, err := NewAuthOptions(o)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := map[string]any{
+		"auth_type": "v3applicationcredential",
+		"auth": map[string]any{
+			"auth_url":                      ao.AuthOptions.IdentityEndpoint,
+			"application_credential_id":     id,
+			"application_credential_secret": secret,
+		},
+	}
+	for k, v := range map[string]string{
+		"region_name": ao.Region,
+		"interface":   firstOf(ao.cloud.Interface, ao.cloud.EndpointType),
+		"cacert":      ao.cloud.CACertFile,
+		"cert":        ao.cloud.ClientCertFile,
+		"key":         ao.cloud.ClientKeyFile,
+	} {
+		if v != "" {
+			entry[k] = v
+		}
+	}
+	if ao.cloud.Verify != nil {
+		entry["verify"] = *ao.cloud.Verify
+	}
+	return entry, nil
+}
+
+// CloudNames returns the names of the clouds in clouds.yaml and secure.yaml.
+func CloudNames() ([]string, error) {
+	clouds, err := readClouds(clientconfig.FindAndReadCloudsYAML)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load clouds.yaml: %w", err)
+	}
+	secure, err := readClouds(clientconfig.FindAndReadSecureCloudsYAML)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load secure.yaml: %w", err)
+	}
+
+	var names []string
+	for _, m := range []map[string]map[string]any{clouds, secure} {
+		for name := range m {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// CloudsFile returns the clouds.yaml file in use, or the one in the user's
+// configuration directory if there is none.
+func CloudsFile() (string, error) {
+	file, _, err := clientconfig.FindAndReadCloudsYAML()
+	if err == nil {
+		return file, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "openstack", "clouds.yaml"), nil
+}
+
+// ReadCloud returns the entry of cloud name in the clouds.yaml file, nil if
+// there is none.
+func ReadCloud(file, name string) (map[string]any, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Clouds map[string]map[string]any `yaml:"clouds"`
+	}
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	return f.Clouds[name], nil
+}
+
+// SaveCloud adds the entry as cloud name to the clouds.yaml file, keeping the
+// rest of the file as it is. An existing entry is replaced.
+func SaveCloud(file, name, comment string, entry map[string]any) error {
+	var doc yaml.Node
+	content, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a clouds.yaml file", file)
+	}
+
+	_, clouds := mappingEntry(root, "clouds")
+	if clouds == nil {
+		clouds = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "clouds"}, clouds)
+	}
+
+	var value yaml.Node
+	if err := value.Encode(entry); err != nil {
+		return err
+	}
+	if key, old := mappingEntry(clouds, name); old != nil {
+		*old = value
+		key.HeadComment = comment
+	} else {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: name, HeadComment: comment}
+		clouds.Content = append(clouds.Content, key, &value)
+	}
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	// the entry contains a secret
+	return os.Chmod(file, 0o600)
+}
+
+// mappingEntry returns the key and value nodes of key in the mapping node,
+// or nil.
+func mappingEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
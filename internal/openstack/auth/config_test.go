@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeMaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		override map[string]any
+		base     map[string]any
+		want     map[string]any
+	}{
+		{
+			name:     "override wins",
+			override: map[string]any{"region_name": "RegionTwo"},
+			base:     map[string]any{"region_name": "RegionOne", "interface": "internal"},
+			want:     map[string]any{"region_name": "RegionTwo", "interface": "internal"},
+		},
+		{
+			name:     "nested maps are merged",
+			override: map[string]any{"auth": map[string]any{"username": "alice"}},
+			base:     map[string]any{"auth": map[string]any{"auth_url": "https://keystone", "username": "admin"}},
+			want:     map[string]any{"auth": map[string]any{"auth_url": "https://keystone", "username": "alice"}},
+		},
+		{
+			name:     "scalar replaces map",
+			override: map[string]any{"auth": "none"},
+			base:     map[string]any{"auth": map[string]any{"auth_url": "https://keystone"}},
+			want:     map[string]any{"auth": "none"},
+		},
+		{
+			name:     "map replaces scalar",
+			override: map[string]any{"auth": map[string]any{"auth_url": "https://keystone"}},
+			base:     map[string]any{"auth": "none"},
+			want:     map[string]any{"auth": map[string]any{"auth_url": "https://keystone"}},
+		},
+		{
+			name: "nil override",
+			base: map[string]any{"verify": false},
+			want: map[string]any{"verify": false},
+		},
+		{
+			name:     "nil base",
+			override: map[string]any{"verify": false},
+			want:     map[string]any{"verify": false},
+		},
+		{
+			name: "both nil",
+			want: map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeMaps(tt.override, tt.base); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeMaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMapsKeepsInputs(t *testing.T) {
+	base := map[string]any{"auth": map[string]any{"auth_url": "https://keystone"}}
+	mergeMaps(map[string]any{"auth": map[string]any{"username": "alice"}}, base)
+	if want := map[string]any{"auth": map[string]any{"auth_url": "https://keystone"}}; !reflect.DeepEqual(base, want) {
+		t.Errorf("base modified to %v", base)
+	}
+}
+
+// writeConfig writes the files to a temporary directory, which becomes the
+// working directory clouds.yaml and friends are found in first.
+func writeConfig(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("OS_CLIENT_CONFIG_FILE", "")
+	t.Chdir(dir)
+}
+
+const (
+	testClouds = `
+cache:
+  expiration_time: 3600
+client:
+  force_ipv4: true
+clouds:
+  prod:
+    profile: acme
+    region_name: RegionTwo
+    auth_type: v3oidcauthcode
+    client_id: top-level
+    auth:
+      username: alice
+      project_name: web
+      client_id: from-auth
+      identity_provider: keycloak
+  legacy:
+    cloud: acme
+  plain:
+    auth:
+      auth_url: https://keystone.plain.example/v3
+    verify: false
+  broken:
+    profile: missing
+`
+	testPublic = `
+public-clouds:
+  acme:
+    region_name: RegionOne
+    interface: internal
+    auth:
+      auth_url: https://keystone.acme.example/v3
+      username: nobody
+`
+	testSecure = `
+clouds:
+  prod:
+    auth:
+      password: secret
+    client_secret: s3cret
+  secret-only:
+    auth:
+      auth_url: https://keystone.secret.example/v3
+      application_credential_id: id
+      application_credential_secret: s3cret
+`
+)
+
+func TestLoadCloud(t *testing.T) {
+	writeConfig(t, map[string]string{
+		"clouds.yaml":        testClouds,
+		"clouds-public.yaml": testPublic,
+		"secure.yaml":        testSecure,
+	})
+
+	t.Run("profile and secure.yaml", func(t *testing.T) {
+		c, gc, err := loadCloud("prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gc.Profile != "" || gc.Cloud != "" {
+			t.Errorf("profile %q/%q left in the merged cloud", gc.Profile, gc.Cloud)
+		}
+		checks := []struct{ name, got, want string }{
+			{"auth_url from the profile", gc.AuthInfo.AuthURL, "https://keystone.acme.example/v3"},
+			{"username from clouds.yaml", gc.AuthInfo.Username, "alice"},
+			{"password from secure.yaml", gc.AuthInfo.Password, "secret"},
+			{"project_name", gc.AuthInfo.ProjectName, "web"},
+			{"region_name from clouds.yaml", gc.RegionName, "RegionTwo"},
+			{"interface from the profile", gc.Interface, "internal"},
+			{"region_name of osssh", c.RegionName, "RegionTwo"},
+			{"auth_type", string(c.AuthType), "v3oidcauthcode"},
+			{"top-level option wins over auth", c.ClientID, "top-level"},
+			{"option from auth", c.IdentityProvider, "keycloak"},
+			{"top-level option from secure.yaml", c.ClientSecret, "s3cret"},
+		}
+		for _, check := range checks {
+			if check.got != check.want {
+				t.Errorf("%s = %q, want %q", check.name, check.got, check.want)
+			}
+		}
+	})
+
+	t.Run("cloud as profile", func(t *testing.T) {
+		_, gc, err := loadCloud("legacy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gc.AuthInfo.AuthURL != "https://keystone.acme.example/v3" || gc.RegionName != "RegionOne" {
+			t.Errorf("profile not merged: auth_url %q, region %q", gc.AuthInfo.AuthURL, gc.RegionName)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		c, _, err := loadCloud("plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Verify == nil || *c.Verify {
+			t.Errorf("verify = %v, want false", c.Verify)
+		}
+	})
+
+	t.Run("only in secure.yaml", func(t *testing.T) {
+		_, gc, err := loadCloud("secret-only")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gc.AuthInfo.ApplicationCredentialID != "id" || gc.AuthInfo.ApplicationCredentialSecret != "s3cret" {
+			t.Errorf("application credential not loaded: %+v", gc.AuthInfo)
+		}
+	})
+
+	for _, name := range []string{"missing", "broken"} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := loadCloud(name); err == nil {
+				t.Error("loadCloud succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCloudNames(t *testing.T) {
+	writeConfig(t, map[string]string{
+		"clouds.yaml": testClouds,
+		"secure.yaml": testSecure,
+	})
+	names, err := CloudNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"broken", "legacy", "plain", "prod", "secret-only"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("CloudNames = %v, want %v", names, want)
+	}
+}
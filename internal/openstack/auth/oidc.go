@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/modzilla99/osssh/internal/openstack/oidc"
+)
+
+// authenticateOIDC exchanges an access token of the identity provider for a
+// Keystone token. A stored or given access token which Keystone rejects is
+// renewed once.
+func authenticateOIDC(ctx context.Context, ao *AuthOptions) (*gophercloud.ProviderClient, error) {
+	cached := true
+	switch ao.AuthType {
+	case Authv3OidcAccessToken:
+	case Authv3OidcPassword:
+		t, err := ao.OIDC.Password(ctx, ao.Username, ao.Password)
+		if err != nil {
+			return nil, err
+		}
+		ao.AccessToken, cached = t.AccessToken, false
+	case Authv3OidcClientCredentials:
+		t, err := ao.OIDC.ClientCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ao.AccessToken, cached = t.AccessToken, false
+	default:
+		t, err := oidc.LoadToken(ao.Cloud)
+		if err != nil {
+			fmt.Printf("Warning: unable to read stored token: %s\n", err)
+		}
+		if t == nil || t.Expired() {
+			t, err = renewAccessToken(ctx, ao, t)
+			if err != nil {
+				return nil, err
+			}
+			cached = false
+		}
+		ao.AccessToken = t.AccessToken
+	}
+
+	provider, err := oidc.AuthenticatedClient(ctx, oidcAuthOptions(ao))
+	if !cached || !gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
+		return provider, err
+	}
+
+	// the token has been revoked or expired before its expiry
+	stored, _ := oidc.LoadToken(ao.Cloud)
+	t, renewErr := renewAccessToken(ctx, ao, stored)
+	if renewErr != nil {
+		return nil, fmt.Errorf("%w, renewing the access token failed: %w", err, renewErr)
+	}
+	ao.AccessToken = t.AccessToken
+	return oidc.AuthenticatedClient(ctx, oidcAuthOptions(ao))
+}
+
+func oidcAuthOptions(ao *AuthOptions) *oidc.AuthOptions {
+	return &oidc.AuthOptions{
+		AccessToken:      ao.AccessToken,
+		IdentityProvider: ao.IdentityProvider,
+		IdentityEndpoint: ao.AuthOptions.IdentityEndpoint,
+		HTTPClient:       ao.HTTPClient,
+		DomainID:         ao.AuthOptions.DomainID,
+		DomainName:       ao.AuthOptions.DomainName,
+		Scope:            tokens.Scope(*ao.AuthOptions.Scope),
+		RenewAccessToken: func(ctx context.Context) (string, error) {
+			t, err := reauthAccessToken(ctx, ao)
+			if err != nil {
+				return "", err
+			}
+			return t.AccessToken, nil
+		},
+	}
+}
+
+// reauthAccessToken gets an access token without user interaction, for
+// clients that have to reauthenticate long after the login.
+func reauthAccessToken(ctx context.Context, ao *AuthOptions) (*oidc.Token, error) {
+	switch ao.AuthType {
+	case Authv3OidcPassword:
+		return ao.OIDC.Password(ctx, ao.Username, ao.Password)
+	case Authv3OidcClientCredentials:
+		return ao.OIDC.ClientCredentials(ctx)
+	}
+
+	// another osssh process may have renewed the token already
+	t, err := oidc.LoadToken(ao.Cloud)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no refresh token stored for cloud %q", ao.Cloud)
+	}
+	if !t.Expired() {
+		return t, nil
+	}
+
+	t, err = ao.OIDC.Refresh(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if err := oidc.SaveToken(ao.Cloud, t); err != nil {
+		fmt.Printf("Warning: unable to store token: %s\n", err)
+	}
+	return t, nil
+}
+
+// renewAccessToken gets a new access token with the refresh token of the
+// stored token, which may be nil. If that fails, the user has to log in
+// again, unless the auth type has no interactive login. The new token is
+// stored.
+func renewAccessToken(ctx context.Context, ao *AuthOptions, stored *oidc.Token) (*oidc.Token, error) {
+	var (
+		t   *oidc.Token
+		err error
+	)
+	if stored != nil && stored.RefreshToken != "" {
+		t, err = ao.OIDC.Refresh(ctx, stored)
+		if err != nil && ao.AuthType != Authv3OidcAccessToken {
+			fmt.Printf("%s, logging in again\n", err)
+		}
+	}
+
+	if t == nil {
+		switch ao.AuthType {
+		case Authv3OidcAuthCode:
+			t, err = ao.OIDC.AuthorizationCode(ctx)
+		case Authv3OidcDeviceAuthz:
+			t, err = ao.OIDC.DeviceAuthorization(ctx)
+		default:
+			if err == nil {
+				err = fmt.Errorf("no refresh token stored for cloud %q", ao.Cloud)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := oidc.SaveToken(ao.Cloud, t); err != nil {
+		fmt.Printf("Warning: unable to store token: %s\n", err)
+	}
+	return t, nil
+}
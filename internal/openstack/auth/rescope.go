@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+)
+
+// Rescope returns a provider scoped to the project with the given id. Instead
+// of logging in again, the token of parent is exchanged for one of the
+// project, so searching many projects doesn't start a login for each. o are
+// the options parent was authenticated with.
+//
+// The new token expires with the one of parent. To reauthenticate, parent
+// reauthenticates and its new token is exchanged again.
+func Rescope(ctx context.Context, parent *gophercloud.ProviderClient, o *clientconfig.ClientOpts, projectID string) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(parent.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient = parent.HTTPClient
+
+	token := parent.Token()
+	catalog, err := exchangeToken(ctx, provider, parent, token, projectID)
+	if err != nil {
+		return nil, err
+	}
+	provider.EndpointLocator = func(opts gophercloud.EndpointOpts) (string, error) {
+		return openstack.V3EndpointURL(catalog, opts)
+	}
+	provider.ReauthFunc = func(ctx context.Context) error {
+		if err := parent.Reauthenticate(ctx, token); err != nil {
+			return err
+		}
+		token = parent.Token()
+		_, err := exchangeToken(ctx, provider, parent, token, projectID)
+		return err
+	}
+
+	setEndpointDefaults(provider, &AuthOptions{
+		Region:       o.RegionName,
+		Availability: clientconfig.GetEndpointType(o.EndpointType),
+	})
+	return provider, nil
+}
+
+// exchangeToken sets the token of provider to one scoped to the project,
+// created from token with the identity endpoint of parent. It returns the
+// service catalog of the new token.
+func exchangeToken(ctx context.Context, provider, parent *gophercloud.ProviderClient, token, projectID string) (*tokens.ServiceCatalog, error) {
+	keystone, err := openstack.NewIdentityV3(parent, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, err
+	}
+	result := tokens.Create(ctx, keystone, &gophercloud.AuthOptions{
+		TokenID: token,
+		Scope:   &gophercloud.AuthScope{ProjectID: projectID},
+	})
+	if err := provider.SetTokenAndAuthResult(result); err != nil {
+		return nil, err
+	}
+
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract service catalog from token: %w", err)
+	}
+	return catalog, nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+)
+
+// fakeKeystone issues the token parent-<n> for every password login and
+// <project>-of-<token> for every token exchanged with the token method.
+type fakeKeystone struct {
+	*httptest.Server
+	mu     sync.Mutex
+	logins int
+}
+
+func newFakeKeystone(t *testing.T) *fakeKeystone {
+	k := &fakeKeystone{}
+	k.Server = httptest.NewServer(http.HandlerFunc(k.serveTokens))
+	t.Cleanup(k.Close)
+	return k
+}
+
+func (k *fakeKeystone) serveTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v3/auth/tokens" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Auth struct {
+			Identity struct {
+				Methods []string `json:"methods"`
+				Token   struct {
+					ID string `json:"id"`
+				} `json:"token"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					ID string `json:"id"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var token string
+	switch id := req.Auth.Identity; {
+	case len(id.Methods) == 1 && id.Methods[0] == "password":
+		k.mu.Lock()
+		k.logins++
+		token = fmt.Sprintf("parent-%d", k.logins)
+		k.mu.Unlock()
+	case len(id.Methods) == 1 && id.Methods[0] == "token":
+		token = req.Auth.Scope.Project.ID + "-of-" + id.Token.ID
+	default:
+		http.Error(w, "unsupported method", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("X-Subject-Token", token)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": {"catalog": [{"type": "identity", "endpoints": [
+		{"interface": "public", "region": "RegionOne", "region_id": "RegionOne", "url": "%[1]s/v3/"},
+		{"interface": "internal", "region": "RegionOne", "region_id": "RegionOne", "url": "%[1]s/internal/v3/"}
+	]}]}}`, k.URL)
+}
+
+func TestRescope(t *testing.T) {
+	ctx := context.Background()
+	k := newFakeKeystone(t)
+
+	parent, err := openstack.AuthenticatedClient(ctx, gophercloud.AuthOptions{
+		IdentityEndpoint: k.URL + "/v3/",
+		Username:         "alice",
+		Password:         "secret",
+		DomainName:       "Default",
+		AllowReauth:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &clientconfig.ClientOpts{RegionName: "RegionOne", EndpointType: "internal"}
+	scoped, err := Rescope(ctx, parent, o, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scoped.Token(); got != "web-of-parent-1" {
+		t.Errorf("token = %q, want web-of-parent-1", got)
+	}
+	if k.logins != 1 {
+		t.Errorf("logged in %d times, want once", k.logins)
+	}
+	url, err := scoped.EndpointLocator(gophercloud.EndpointOpts{Type: "identity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := k.URL + "/internal/v3/"; url != want {
+		t.Errorf("endpoint = %q, want the internal one %q", url, want)
+	}
+
+	// the expired token is renewed through the parent
+	if err := scoped.Reauthenticate(ctx, scoped.Token()); err != nil {
+		t.Fatal(err)
+	}
+	if got := parent.Token(); got != "parent-2" {
+		t.Errorf("parent token = %q, want parent-2", got)
+	}
+	if got := scoped.Token(); got != "web-of-parent-2" {
+		t.Errorf("token = %q after reauthentication, want web-of-parent-2", got)
+	}
+}
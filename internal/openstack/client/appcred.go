@@ -0,0 +1,163 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+)
+
+// appCredentialRules are the read-only API calls osssh makes, relative to the
+// endpoints of the services: reading servers, keypairs and server groups
+// from Nova and ports, networks and security group rules from Neutron.
+var appCredentialRules = []applicationcredentials.AccessRule{
+	{Service: "compute", Method: "GET", Path: "/servers"},
+	{Service: "compute", Method: "GET", Path: "/servers/detail"},
+	{Service: "compute", Method: "GET", Path: "/servers/*"},
+	{Service: "compute", Method: "GET", Path: "/os-keypairs/*"},
+	{Service: "compute", Method: "GET", Path: "/os-server-groups"},
+	{Service: "network", Method: "GET", Path: "/ports"},
+	{Service: "network", Method: "GET", Path: "/networks"},
+	{Service: "network", Method: "GET", Path: "/security-group-rules"},
+}
+
+// appCredentialConsoleLogRule allows reading the console log of servers,
+// which -verify-host-keys needs. Access rules can't limit the body of the
+// request, so it allows every other server action as well, like reboot,
+// rebuild, resize, stop and createImage.
+var appCredentialConsoleLogRule = applicationcredentials.AccessRule{
+	Service: "compute", Method: "POST", Path: "/servers/*/action",
+}
+
+// AppCredentialAccessRules returns the access rules allowing the API calls
+// of osssh, and reading console logs if consoleLog is set. The paths start
+// with those of the compute and network endpoints in the catalog, which may
+// carry a prefix or the project id, like /compute/v2.1/<project_id>/servers.
+func (c *OpenStackClient) AppCredentialAccessRules(consoleLog bool) ([]applicationcredentials.AccessRule, error) {
+	nova, err := c.GetNovaClient()
+	if err != nil {
+		return nil, err
+	}
+	neutron, err := c.GetNeutronClient()
+	if err != nil {
+		return nil, err
+	}
+	prefixes := map[string]string{
+		"compute": endpointPath(nova.ResourceBaseURL()),
+		"network": endpointPath(neutron.ResourceBaseURL()),
+	}
+
+	rules := slices.Clone(appCredentialRules)
+	if consoleLog {
+		rules = append(rules, appCredentialConsoleLogRule)
+	}
+	for i := range rules {
+		rules[i].Path = prefixes[rules[i].Service] + rules[i].Path
+	}
+	return rules, nil
+}
+
+// endpointPath returns the path of the endpoint without the trailing slash,
+// which the paths of requests to the service start with.
+func endpointPath(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// AppCredentialOpts are the restrictions of a new application credential.
+type AppCredentialOpts struct {
+	Name      string
+	ExpiresAt time.Time
+	// Roles are the names of the roles of the token the credential gets, all
+	// roles if empty
+	Roles []string
+	// AccessRules limit the API calls of the credential, none if empty
+	AccessRules []applicationcredentials.AccessRule
+}
+
+// tokenUser returns the user and roles of the token of the client.
+func (c *OpenStackClient) tokenUser() (*tokens.User, []tokens.Role, error) {
+	r, ok := c.ProviderClient.GetAuthResult().(interface {
+		ExtractUser() (*tokens.User, error)
+		ExtractRoles() ([]tokens.Role, error)
+	})
+	if !ok {
+		return nil, nil, errors.New("unable to get user from token")
+	}
+	user, err := r.ExtractUser()
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, err := r.ExtractRoles()
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, roles, nil
+}
+
+func (c *OpenStackClient) getKeystoneClient() (*gophercloud.ServiceClient, error) {
+	return openstack.NewIdentityV3(c.ProviderClient, gophercloud.EndpointOpts{Region: c.Region})
+}
+
+// CreateAppCredential creates an application credential of the user of the
+// client for the project the client is scoped to.
+func (c *OpenStackClient) CreateAppCredential(ctx context.Context, opts AppCredentialOpts) (*applicationcredentials.ApplicationCredential, error) {
+	user, tokenRoles, err := c.tokenUser()
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []applicationcredentials.Role
+	for _, r := range tokenRoles {
+		if len(opts.Roles) == 0 || slices.Contains(opts.Roles, r.Name) {
+			roles = append(roles, applicationcredentials.Role{ID: r.ID})
+		}
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("the token has none of the roles %v", opts.Roles)
+	}
+
+	keystone, err := c.getKeystoneClient()
+	if err != nil {
+		return nil, err
+	}
+	createOpts := applicationcredentials.CreateOpts{
+		Name:        opts.Name,
+		Description: "Created by osssh login",
+		Roles:       roles,
+		AccessRules: opts.AccessRules,
+	}
+	if !opts.ExpiresAt.IsZero() {
+		createOpts.ExpiresAt = &opts.ExpiresAt
+	}
+	ac, err := applicationcredentials.Create(ctx, keystone, user.ID, createOpts).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create application credential: %w", err)
+	}
+	return ac, nil
+}
+
+// DeleteAppCredential deletes an application credential of the user of the
+// client.
+func (c *OpenStackClient) DeleteAppCredential(ctx context.Context, id string) error {
+	user, _, err := c.tokenUser()
+	if err != nil {
+		return err
+	}
+	keystone, err := c.getKeystoneClient()
+	if err != nil {
+		return err
+	}
+	return applicationcredentials.Delete(ctx, keystone, user.ID, id).ExtractErr()
+}
@@ -0,0 +1,22 @@
+package openstack
+
+import "testing"
+
+func TestEndpointPath(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "https://nova.example.com:8774/v2.1/", want: "/v2.1"},
+		{endpoint: "https://nova.example.com:8774/v2.1/6f0b6e2f9a2c4b1d8e3f4a5b6c7d8e9f/", want: "/v2.1/6f0b6e2f9a2c4b1d8e3f4a5b6c7d8e9f"},
+		{endpoint: "https://cloud.example.com/compute/v2.1/", want: "/compute/v2.1"},
+		{endpoint: "https://neutron.example.com:9696/v2.0/", want: "/v2.0"},
+		{endpoint: "https://cloud.example.com/network/v2.0/", want: "/network/v2.0"},
+		{endpoint: "https://neutron.example.com:9696/", want: ""},
+	}
+	for _, tt := range tests {
+		if got := endpointPath(tt.endpoint); got != tt.want {
+			t.Errorf("endpointPath(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
@@ -3,23 +3,65 @@ package openstack
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/security/rules"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/hashicorp/go-uuid"
 	"github.com/modzilla99/osssh/types/openstack/neutron"
 )
 
 func (c *OpenStackClient) GetNeutronClient() (*gophercloud.ServiceClient, error) {
-	return openstack.NewNetworkV2(c.ProviderClient, gophercloud.EndpointOpts{})
+	return openstack.NewNetworkV2(c.ProviderClient, gophercloud.EndpointOpts{Region: c.Region})
 }
 
-func getNeutronPortByServerID(ctx context.Context, c *gophercloud.ServiceClient, id string) (*neutron.Port, error) {
+// allTenantsPortOpts lists the ports of all projects. Neutron shows them to
+// admins by default, but not with every policy.
+type allTenantsPortOpts struct {
+	ports.ListOpts
+}
+
+func (o allTenantsPortOpts) ToPortListQuery() (string, error) {
+	q, err := o.ListOpts.ToPortListQuery()
+	return withAllTenants(q), err
+}
+
+type allTenantsNetworkOpts struct {
+	networks.ListOpts
+}
+
+func (o allTenantsNetworkOpts) ToNetworkListQuery() (string, error) {
+	q, err := o.ListOpts.ToNetworkListQuery()
+	return withAllTenants(q), err
+}
+
+func withAllTenants(query string) string {
+	if query == "" {
+		return "?all_tenants=1"
+	}
+	return query + "&all_tenants=1"
+}
+
+func portListOpts(opts ports.ListOpts, allTenants bool) ports.ListOptsBuilder {
+	if allTenants {
+		return allTenantsPortOpts{opts}
+	}
+	return opts
+}
+
+func getNeutronPortByServerID(ctx context.Context, c *gophercloud.ServiceClient, id, networkID string, allTenants bool) (*neutron.Port, error) {
 	s := ports.ListOpts{
-		DeviceID: id,
-		Limit:    1,
+		DeviceID:  id,
+		NetworkID: networkID,
+		Limit:     1,
 	}
-	p, err := ports.List(c, s).AllPages(ctx)
+	p, err := ports.List(c, portListOpts(s, allTenants)).AllPages(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +73,129 @@ func getNeutronPortByServerID(ctx context.Context, c *gophercloud.ServiceClient,
 	}
 
 	if len(ps) == 0 {
+		if networkID != "" {
+			return nil, errors.New("no port found for server with id: " + id + " on network: " + networkID)
+		}
 		return nil, errors.New("no port found for server with id: " + id)
 	}
 	return &ps[0], nil
 }
+
+// getNeutronPortByAddress returns the port of a server with the fixed IP
+// address.
+func getNeutronPortByAddress(ctx context.Context, c *gophercloud.ServiceClient, address string, allTenants bool) (*neutron.Port, error) {
+	p, err := ports.List(c, portListOpts(ports.ListOpts{
+		FixedIPs: []ports.FixedIPOpts{{IPAddress: address}},
+	}, allTenants)).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := []neutron.Port{}
+	if err := ports.ExtractPortsInto(p, &ps); err != nil {
+		return nil, err
+	}
+	ps = slices.DeleteFunc(ps, func(p neutron.Port) bool {
+		return !strings.HasPrefix(p.DeviceOwner, "compute:")
+	})
+
+	switch len(ps) {
+	case 0:
+		return nil, errors.New("no server found with address " + address)
+	case 1:
+		return &ps[0], nil
+	default:
+		return nil, fmt.Errorf("found %d servers with address %s, please specify the server name or uuid", len(ps), address)
+	}
+}
+
+// resolveNetworkID returns the id of the network, which may be given by its
+// name or uuid.
+func resolveNetworkID(ctx context.Context, c *gophercloud.ServiceClient, network string, allTenants bool) (string, error) {
+	if network == "" {
+		return "", nil
+	}
+	if _, err := uuid.ParseUUID(network); err == nil {
+		return network, nil
+	}
+
+	var opts networks.ListOptsBuilder = networks.ListOpts{Name: network}
+	if allTenants {
+		opts = allTenantsNetworkOpts{networks.ListOpts{Name: network}}
+	}
+	p, err := networks.List(c, opts).AllPages(ctx)
+	if err != nil {
+		return "", err
+	}
+	ns, err := networks.ExtractNetworks(p)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(ns) {
+	case 0:
+		return "", errors.New("network with name " + network + " could not be found")
+	case 1:
+		return ns[0].ID, nil
+	default:
+		return "", fmt.Errorf("found %d networks with name %s, please specify the uuid", len(ns), network)
+	}
+}
+
+// EgressAllowed reports whether the security groups of the server allow it
+// to reach address on the given protocol and port.
+func EgressAllowed(ctx context.Context, osc *OpenStackClient, info *Info, protocol, address string, port int) (bool, error) {
+	if !info.PortSecurityEnabled {
+		return true, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false, fmt.Errorf("invalid ip address: %s", address)
+	}
+	etherType := rules.EtherType4
+	if ip.To4() == nil {
+		etherType = rules.EtherType6
+	}
+
+	c, err := osc.GetNeutronClient()
+	if err != nil {
+		return false, err
+	}
+
+	for _, sg := range info.SecurityGroups {
+		p, err := rules.List(c, rules.ListOpts{
+			Direction:  string(rules.DirEgress),
+			EtherType:  string(etherType),
+			SecGroupID: sg,
+		}).AllPages(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		rs, err := rules.ExtractRules(p)
+		if err != nil {
+			return false, err
+		}
+
+		for _, r := range rs {
+			if r.Protocol != "" && r.Protocol != protocol {
+				continue
+			}
+			if r.PortRangeMin != 0 && (port < r.PortRangeMin || port > r.PortRangeMax) {
+				continue
+			}
+			if r.RemoteGroupID != "" || r.RemoteAddressGroupID != "" {
+				continue
+			}
+			if r.RemoteIPPrefix != "" {
+				_, prefix, err := net.ParseCIDR(r.RemoteIPPrefix)
+				if err != nil || !prefix.Contains(ip) {
+					continue
+				}
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -3,25 +3,87 @@ package openstack
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/keypairs"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-uuid"
 	"github.com/modzilla99/osssh/types/openstack/nova"
 )
 
+// ErrServerNotFound is returned if the server doesn't exist in the project
+// of the client.
+var ErrServerNotFound = errors.New("could not be found")
+
 func (c *OpenStackClient) GetNovaClient() (*gophercloud.ServiceClient, error) {
-	return openstack.NewComputeV2(c.ProviderClient, gophercloud.EndpointOpts{})
+	return openstack.NewComputeV2(c.ProviderClient, gophercloud.EndpointOpts{Region: c.Region})
 }
 
-func getServerByID(c *gophercloud.ServiceClient, id string) (*nova.Server, error) {
+func getServerByID(ctx context.Context, c *gophercloud.ServiceClient, id string) (*nova.Server, error) {
 	s := &nova.Server{}
-	if err := servers.Get(context.TODO(), c, id).ExtractInto(s); err != nil {
+	if err := servers.Get(ctx, c, id).ExtractInto(s); err != nil {
 		if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
-			return nil, errors.New("server with id " + id + " could not be found")
+			return nil, fmt.Errorf("server with id %s %w", id, ErrServerNotFound)
 		}
 		return nil, err
 	}
 	return s, nil
 }
+
+// resolveServerID returns the id of the server, which may be given by its
+// name or uuid.
+func resolveServerID(ctx context.Context, c *gophercloud.ServiceClient, server string, allTenants bool) (string, error) {
+	if _, err := uuid.ParseUUID(server); err == nil {
+		return server, nil
+	}
+
+	p, err := servers.List(c, servers.ListOpts{
+		Name:       "^" + regexp.QuoteMeta(server) + "$",
+		AllTenants: allTenants,
+	}).AllPages(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ss := make([]nova.Server, 0, 1)
+	err = servers.ExtractServersInto(p, &ss)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(ss) {
+	case 0:
+		return "", fmt.Errorf("server with name %s %w", server, ErrServerNotFound)
+	case 1:
+		return ss[0].ID, nil
+	default:
+		return "", fmt.Errorf("found %d servers with name %s, please specify the uuid", len(ss), server)
+	}
+}
+
+// GetConsoleOutput returns the complete console log of the server.
+func GetConsoleOutput(ctx context.Context, osc *OpenStackClient, id string) (string, error) {
+	c, err := osc.GetNovaClient()
+	if err != nil {
+		return "", err
+	}
+	return servers.ShowConsoleOutput(ctx, c, id, servers.ShowConsoleOutputOpts{}).Extract()
+}
+
+// GetKeyPairPublicKey returns the public key of the Nova keypair with the
+// given name in OpenSSH format.
+func GetKeyPairPublicKey(ctx context.Context, osc *OpenStackClient, name string) (string, error) {
+	c, err := osc.GetNovaClient()
+	if err != nil {
+		return "", err
+	}
+	kp, err := keypairs.Get(ctx, c, name, nil).Extract()
+	if err != nil {
+		return "", err
+	}
+	return kp.PublicKey, nil
+}
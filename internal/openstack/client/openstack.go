@@ -10,39 +10,104 @@ import (
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
 	"github.com/modzilla99/osssh/internal/openstack/auth"
+	"github.com/modzilla99/osssh/internal/progress"
 	"github.com/modzilla99/osssh/types/openstack/neutron"
 	"github.com/modzilla99/osssh/types/openstack/nova"
 )
 
 type Info struct {
-	ServerName         string
-	HypervisorHostname string
-	IPAddress          string
-	NetworkID          string
+	ServerID            string
+	ServerName          string
+	HypervisorHostname  string
+	Region              string
+	ProjectID           string
+	ProjectName         string
+	ProjectDomain       string
+	OwnerID             string
+	OwnerName           string
+	IPAddress           string
+	NetworkID           string
+	KeyName             string
+	SecurityGroups      []string
+	PortSecurityEnabled bool
 }
 
 type OpenStackClient struct {
 	ProviderClient *gophercloud.ProviderClient
-	auth           *clientconfig.ClientOpts
+	// Region selects the endpoints of the services, the region of the
+	// configuration if empty
+	Region string
+	// AllProjects makes admins look up servers in all projects instead of
+	// only the one the client is scoped to
+	AllProjects bool
+	auth        *clientconfig.ClientOpts
 }
 
 func CreateClient(ctx context.Context) (*OpenStackClient, error) {
-	fmt.Print("Authenticating to OpenStack...")
-	opts := &clientconfig.ClientOpts{}
+	return CreateClientForCloud(ctx, "")
+}
+
+// CreateClientForCloud authenticates to the cloud with the given name in
+// clouds.yaml. An empty name selects the cloud from the environment.
+func CreateClientForCloud(ctx context.Context, cloud string) (*OpenStackClient, error) {
+	progress.Print("Authenticating to OpenStack...")
+	opts := &clientconfig.ClientOpts{Cloud: cloud}
 	provider, err := auth.Authenticate(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("Done")
-	return &OpenStackClient{
+	progress.Println("Done")
+	osc := &OpenStackClient{
 		ProviderClient: provider,
-		auth:           opts,
-	}, nil
+		// the region resolved from the configuration
+		Region: opts.RegionName,
+		auth:   opts,
+	}
+	if osc.Region == "" {
+		if regions, err := osc.Regions(); err == nil && len(regions) == 1 {
+			osc.Region = regions[0]
+		}
+	}
+	return osc, nil
+}
+
+// CloudName returns the name of the cloud in clouds.yaml the client is
+// authenticated to, empty if it was configured by the environment.
+func (c *OpenStackClient) CloudName() string {
+	return c.auth.Cloud
+}
+
+// ProjectName returns the name of the project the client is scoped to.
+func (c *OpenStackClient) ProjectName() (string, error) {
+	p, err := c.currentProject()
+	if err != nil {
+		return "", err
+	}
+	return p.Name, nil
+}
+
+// GetInfo fetches everything needed to reach the server, which may be given
+// by its name or uuid.
+func GetInfo(ctx context.Context, osc *OpenStackClient, server string) (*Info, error) {
+	return GetInfoOnNetwork(ctx, osc, server, "")
+}
+
+// GetInfoOnNetwork is like GetInfo, but uses the port of the server on the
+// given network (name or id). An empty network selects the first port.
+func GetInfoOnNetwork(ctx context.Context, osc *OpenStackClient, server, network string) (*Info, error) {
+	progress.Print("Fetching data from OpenStack...")
+	info, err := getInfo(ctx, osc, server, network)
+	if err != nil {
+		return nil, err
+	}
+	progress.Println("Done")
+	return info, nil
 }
 
-func GetInfo(ctx context.Context, osc *OpenStackClient, uuid string) (*Info, error) {
+func getInfo(ctx context.Context, osc *OpenStackClient, server, network string) (*Info, error) {
 	var (
 		wg         sync.WaitGroup
+		mu         sync.Mutex
 		s          *nova.Server
 		serverPort *neutron.Port
 		nova       *gophercloud.ServiceClient
@@ -54,7 +119,6 @@ func GetInfo(ctx context.Context, osc *OpenStackClient, uuid string) (*Info, err
 	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	fmt.Print("Fetching data from OpenStack...")
 	neutron, err = osc.GetNeutronClient()
 	if err != nil {
 		return nil, err
@@ -65,19 +129,33 @@ func GetInfo(ctx context.Context, osc *OpenStackClient, uuid string) (*Info, err
 		return nil, err
 	}
 
+	uuid, err := resolveServerID(ctx, nova, server, osc.AllProjects)
+	if err != nil {
+		return nil, err
+	}
+
+	networkID, err := resolveNetworkID(ctx, neutron, network, osc.AllProjects)
+	if err != nil {
+		return nil, err
+	}
+
 	wg.Go(func() {
 		var e error
-		s, e = getServerByID(nova, uuid)
+		s, e = getServerByID(ctx, nova, uuid)
 		if e != nil {
+			mu.Lock()
 			errs = append(errs, fmt.Errorf("getServerByID: %w", e))
+			mu.Unlock()
 		}
 	})
 
 	wg.Go(func() {
 		var e error
-		serverPort, e = getNeutronPortByServerID(ctx, neutron, uuid)
+		serverPort, e = getNeutronPortByServerID(ctx, neutron, uuid, networkID, osc.AllProjects)
 		if e != nil {
+			mu.Lock()
 			errs = append(errs, fmt.Errorf("getNeutronPortByServerID: %w", e))
+			mu.Unlock()
 			return
 		}
 	})
@@ -89,11 +167,56 @@ func GetInfo(ctx context.Context, osc *OpenStackClient, uuid string) (*Info, err
 		return nil, errors.Join(errs...)
 	}
 
-	fmt.Println("Done")
+	info := newInfo(s, serverPort)
+	info.Region = osc.Region
+	osc.describeOwner(ctx, info)
+	return info, nil
+}
+
+// GetInfoByAddress fetches the info of the server owning the port with the
+// given fixed IP address.
+func GetInfoByAddress(ctx context.Context, osc *OpenStackClient, address string) (*Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	progress.Print("Fetching data from OpenStack...")
+	neutronClient, err := osc.GetNeutronClient()
+	if err != nil {
+		return nil, err
+	}
+	novaClient, err := osc.GetNovaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := getNeutronPortByAddress(ctx, neutronClient, address, osc.AllProjects)
+	if err != nil {
+		return nil, err
+	}
+	s, err := getServerByID(ctx, novaClient, p.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Println("Done")
+	info := newInfo(s, p)
+	info.IPAddress = address
+	info.Region = osc.Region
+	osc.describeOwner(ctx, info)
+	return info, nil
+}
+
+func newInfo(s *nova.Server, p *neutron.Port) *Info {
 	return &Info{
-		ServerName:         s.Name,
-		HypervisorHostname: s.HypervisorHostname,
-		IPAddress:          serverPort.FixedIPs[0].IPAddress,
-		NetworkID:          serverPort.NetworkID,
-	}, nil
+		ServerID:            s.ID,
+		ServerName:          s.Name,
+		HypervisorHostname:  s.HypervisorHostname,
+		IPAddress:           p.FixedIPs[0].IPAddress,
+		NetworkID:           p.NetworkID,
+		KeyName:             s.KeyName,
+		ProjectID:           s.TenantID,
+		OwnerID:             s.UserID,
+		SecurityGroups:      p.SecurityGroups,
+		PortSecurityEnabled: p.PortSecurityEnabled == nil || *p.PortSecurityEnabled,
+	}
 }
@@ -0,0 +1,202 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/domains"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/users"
+	"github.com/modzilla99/osssh/internal/openstack/auth"
+	"github.com/modzilla99/osssh/internal/progress"
+	"golang.org/x/sync/errgroup"
+)
+
+// CreateClientForProject is like CreateClientForCloud, but scopes the client
+// to the project with the given name or id. An empty project selects the one
+// of the configuration.
+func CreateClientForProject(ctx context.Context, cloud, project string) (*OpenStackClient, error) {
+	osc, err := CreateClientForCloud(ctx, cloud)
+	if err != nil || project == "" {
+		return osc, err
+	}
+	return osc.Rescope(ctx, project)
+}
+
+// currentProject returns the project the client is scoped to.
+func (c *OpenStackClient) currentProject() (*tokens.Project, error) {
+	r, ok := c.ProviderClient.GetAuthResult().(interface {
+		ExtractProject() (*tokens.Project, error)
+	})
+	if !ok {
+		return nil, errors.New("unable to get project from token")
+	}
+	p, err := r.ExtractProject()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.New("token is not scoped to a project")
+	}
+	return p, nil
+}
+
+// ProjectID returns the id of the project the client is scoped to.
+func (c *OpenStackClient) ProjectID() (string, error) {
+	p, err := c.currentProject()
+	if err != nil {
+		return "", err
+	}
+	return p.ID, nil
+}
+
+// Projects returns the projects the user of the client can scope to.
+func (c *OpenStackClient) Projects(ctx context.Context) ([]projects.Project, error) {
+	keystone, err := c.getKeystoneClient()
+	if err != nil {
+		return nil, err
+	}
+	p, err := projects.ListAvailable(keystone).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list projects: %w", err)
+	}
+	return projects.ExtractProjects(p)
+}
+
+// Rescope returns a client for the same user scoped to the project with the
+// given name or id.
+func (c *OpenStackClient) Rescope(ctx context.Context, project string) (*OpenStackClient, error) {
+	if current, err := c.currentProject(); err == nil && (current.ID == project || current.Name == project) {
+		return c, nil
+	}
+
+	ps, err := c.Projects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matches []projects.Project
+	for _, p := range ps {
+		if p.ID == project || p.Name == project {
+			matches = append(matches, p)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("project %s could not be found", project)
+	case 1:
+		return c.rescopeTo(ctx, matches[0].ID)
+	default:
+		return nil, fmt.Errorf("found %d projects with name %s, please specify the id", len(matches), project)
+	}
+}
+
+func (c *OpenStackClient) rescopeTo(ctx context.Context, projectID string) (*OpenStackClient, error) {
+	provider, err := auth.Rescope(ctx, c.ProviderClient, c.auth, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scope to project %s: %w", projectID, err)
+	}
+	return &OpenStackClient{ProviderClient: provider, Region: c.Region, AllProjects: c.AllProjects, auth: c.auth}, nil
+}
+
+// GetInfoInProjects is like GetInfo, but searches all projects the user can
+// access if the server isn't in the project of the client. The client scoped
+// to the project of the server is returned with its info.
+func GetInfoInProjects(ctx context.Context, osc *OpenStackClient, server string) (*OpenStackClient, *Info, error) {
+	progress.Print("Fetching data from OpenStack...")
+	info, err := getInfo(ctx, osc, server, "")
+	if err == nil {
+		progress.Println("Done")
+		return osc, info, nil
+	}
+	if !errors.Is(err, ErrServerNotFound) {
+		return nil, nil, err
+	}
+	notFound := err
+
+	current, _ := osc.ProjectID()
+	ps, err := osc.Projects(ctx)
+	if err != nil || len(ps) < 2 {
+		return nil, nil, notFound
+	}
+	progress.Printf("Not found, searching %d other projects...", len(ps)-1)
+
+	type match struct {
+		osc  *OpenStackClient
+		info *Info
+		name string
+	}
+	var (
+		mu      sync.Mutex
+		matches []match
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(8)
+	for _, p := range ps {
+		if p.ID == current || !p.Enabled {
+			continue
+		}
+		g.Go(func() error {
+			// projects the user can't scope to are skipped, e.g. with an
+			// application credential
+			scoped, err := osc.rescopeTo(gctx, p.ID)
+			if err != nil {
+				return nil
+			}
+			info, err := getInfo(gctx, scoped, server, "")
+			if err != nil {
+				return nil
+			}
+			mu.Lock()
+			matches = append(matches, match{osc: scoped, info: info, name: p.Name})
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+
+	switch len(matches) {
+	case 0:
+		return nil, nil, notFound
+	case 1:
+		progress.Printf("Found in project %s\n", matches[0].name)
+		return matches[0].osc, matches[0].info, nil
+	default:
+		return nil, nil, fmt.Errorf("found server %s in %d projects, please select one with -project", server, len(matches))
+	}
+}
+
+// describeOwner fills in the names of the project and owner of the server.
+// Those of the token are used if they match, otherwise they are looked up in
+// Keystone with AllProjects. Names that can't be found are left empty.
+func (c *OpenStackClient) describeOwner(ctx context.Context, info *Info) {
+	if p, err := c.currentProject(); err == nil && p.ID == info.ProjectID {
+		info.ProjectName, info.ProjectDomain = p.Name, p.Domain.Name
+	}
+	if u, _, err := c.tokenUser(); err == nil && u.ID == info.OwnerID {
+		info.OwnerName = u.Name
+	}
+	if !c.AllProjects {
+		return
+	}
+
+	keystone, err := c.getKeystoneClient()
+	if err != nil {
+		return
+	}
+	if info.ProjectName == "" && info.ProjectID != "" {
+		if p, err := projects.Get(ctx, keystone, info.ProjectID).Extract(); err == nil {
+			info.ProjectName, info.ProjectDomain = p.Name, p.DomainID
+			if d, err := domains.Get(ctx, keystone, p.DomainID).Extract(); err == nil {
+				info.ProjectDomain = d.Name
+			}
+		}
+	}
+	if info.OwnerName == "" && info.OwnerID != "" {
+		if u, err := users.Get(ctx, keystone, info.OwnerID).Extract(); err == nil {
+			info.OwnerName = u.Name
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"golang.org/x/sync/errgroup"
+)
+
+// Regions returns the regions with a compute endpoint in the catalog of the
+// token of the client.
+func (c *OpenStackClient) Regions() ([]string, error) {
+	r, ok := c.ProviderClient.GetAuthResult().(interface {
+		ExtractServiceCatalog() (*tokens.ServiceCatalog, error)
+	})
+	if !ok {
+		return nil, errors.New("unable to get service catalog from token")
+	}
+	catalog, err := r.ExtractServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	for _, e := range catalog.Entries {
+		if e.Type != "compute" {
+			continue
+		}
+		for _, ep := range e.Endpoints {
+			if ep.Region != "" && !slices.Contains(regions, ep.Region) {
+				regions = append(regions, ep.Region)
+			}
+		}
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// InRegion returns a client using the same session for the services of the
+// given region.
+func (c *OpenStackClient) InRegion(region string) *OpenStackClient {
+	return &OpenStackClient{ProviderClient: c.ProviderClient, Region: region, AllProjects: c.AllProjects, auth: c.auth}
+}
+
+// SearchServer looks for the server with all clients in parallel and returns
+// the first one finding it with its info. Clients of different clouds or
+// regions can be mixed.
+func SearchServer(ctx context.Context, clients []*OpenStackClient, server string) (*OpenStackClient, *Info, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once  sync.Once
+		found *OpenStackClient
+		info  *Info
+		mu    sync.Mutex
+		errs  []error
+	)
+	g := errgroup.Group{}
+	g.SetLimit(8)
+	for _, osc := range clients {
+		g.Go(func() error {
+			i, err := getInfo(ctx, osc, server, "")
+			if err != nil {
+				// lookups cancelled by an earlier match are no errors
+				if ctx.Err() == nil && !errors.Is(err, ErrServerNotFound) {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", osc.location(), err))
+					mu.Unlock()
+				}
+				return nil
+			}
+			once.Do(func() {
+				found, info = osc, i
+				cancel()
+			})
+			return nil
+		})
+	}
+	g.Wait()
+
+	if found != nil {
+		return found, info, nil
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return nil, nil, fmt.Errorf("server %s %w in any of %d clouds or regions", server, ErrServerNotFound, len(clients))
+}
+
+// location describes the cloud and region of the client for messages.
+func (c *OpenStackClient) location() string {
+	name := c.CloudName()
+	if name == "" {
+		name = "environment"
+	}
+	if c.Region != "" {
+		return fmt.Sprintf("cloud %s region %s", name, c.Region)
+	}
+	return "cloud " + name
+}
@@ -0,0 +1,117 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/types/openstack/neutron"
+	"github.com/modzilla99/osssh/types/openstack/nova"
+)
+
+// ServerFilter selects the servers returned by FindServers. Without any
+// filter all servers of the project are returned.
+type ServerFilter struct {
+	// Tags all have to be set on a server.
+	Tags []string
+	// ServerGroup is the name or id of a server group.
+	ServerGroup string
+}
+
+// FindServers fetches the info of all servers matching the filter. Servers
+// without a port are skipped.
+func FindServers(ctx context.Context, osc *OpenStackClient, f ServerFilter) ([]*Info, error) {
+	progress.Print("Fetching data from OpenStack...")
+	novaClient, err := osc.GetNovaClient()
+	if err != nil {
+		return nil, err
+	}
+	neutronClient, err := osc.GetNeutronClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var members map[string]bool
+	if f.ServerGroup != "" {
+		members, err = getServerGroupMembers(ctx, osc, f.ServerGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := servers.List(novaClient, servers.ListOpts{
+		Tags:       strings.Join(f.Tags, ","),
+		AllTenants: osc.AllProjects,
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ss := []nova.Server{}
+	if err := servers.ExtractServersInto(p, &ss); err != nil {
+		return nil, err
+	}
+
+	pp, err := ports.List(neutronClient, portListOpts(ports.ListOpts{}, osc.AllProjects)).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ps := []neutron.Port{}
+	if err := ports.ExtractPortsInto(pp, &ps); err != nil {
+		return nil, err
+	}
+	portByDevice := make(map[string]*neutron.Port, len(ps))
+	for i := range ps {
+		if _, ok := portByDevice[ps[i].DeviceID]; !ok && len(ps[i].FixedIPs) > 0 {
+			portByDevice[ps[i].DeviceID] = &ps[i]
+		}
+	}
+
+	infos := make([]*Info, 0, len(ss))
+	for i := range ss {
+		if members != nil && !members[ss[i].ID] {
+			continue
+		}
+		port, ok := portByDevice[ss[i].ID]
+		if !ok {
+			continue
+		}
+		info := newInfo(&ss[i], port)
+		info.Region = osc.Region
+		infos = append(infos, info)
+	}
+
+	progress.Println("Done")
+	return infos, nil
+}
+
+func getServerGroupMembers(ctx context.Context, osc *OpenStackClient, group string) (map[string]bool, error) {
+	c, err := osc.GetNovaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := servergroups.List(c, servergroups.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := servergroups.ExtractServerGroups(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if g.ID != group && g.Name != group {
+			continue
+		}
+		members := make(map[string]bool, len(g.Members))
+		for _, m := range g.Members {
+			members[m] = true
+		}
+		return members, nil
+	}
+	return nil, fmt.Errorf("server group %s could not be found", group)
+}
@@ -0,0 +1,185 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// DefaultRedirectPort is the port of the loopback callback if none is
+// configured, the same keystoneauth uses.
+const DefaultRedirectPort = 8080
+
+// AuthorizationCode logs in with the authorization code grant and PKCE. The
+// authorization URL is opened in a browser, the identity provider redirects
+// it back to a listener on the loopback interface with the code.
+func (c *ClientConfig) AuthorizationCode(ctx context.Context) (*Token, error) {
+	m, err := c.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("identity provider %s has no authorization endpoint", m.Issuer)
+	}
+
+	redirectURI, addresses, err := c.redirect()
+	if err != nil {
+		return nil, err
+	}
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			continue
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("unable to listen for the login callback on %s", redirectURI)
+	}
+
+	verifier := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+	state := randomString()
+
+	authURL, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", c.scope())
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			res.err = &tokenError{Code: q.Get("error"), Description: q.Get("error_description")}
+		case q.Get("code") == "":
+			res.err = errors.New("identity provider returned no authorization code")
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, "Login failed: "+res.err.Error(), http.StatusUnauthorized)
+		} else {
+			fmt.Fprintln(w, "Login successful, you can close this window.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	for _, listener := range listeners {
+		go srv.Serve(listener)
+	}
+	defer srv.Close()
+
+	fmt.Printf("To log in, open %s\n", authURL)
+	openBrowser(authURL.String())
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-results:
+	}
+	if res.err != nil {
+		return nil, fmt.Errorf("authorization failed: %w", res.err)
+	}
+
+	t, err := c.requestToken(ctx, m.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {res.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange authorization code: %w", err)
+	}
+	return t, nil
+}
+
+// redirect returns the redirect URI of the client and the addresses the
+// callback is received on. Without a configured URI the loopback IP is used
+// rather than localhost, which the browser may resolve to ::1 (RFC 8252).
+func (c *ClientConfig) redirect() (string, []string, error) {
+	if c.RedirectURI == "" {
+		port := c.RedirectPort
+		if port == 0 {
+			port = DefaultRedirectPort
+		}
+		return fmt.Sprintf("http://127.0.0.1:%d/", port), []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}, nil
+	}
+
+	u, err := url.Parse(c.RedirectURI)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid redirect_uri: %w", err)
+	}
+	if u.Scheme != "http" {
+		return "", nil, fmt.Errorf("invalid redirect_uri %s: only http callbacks on the loopback interface are supported", c.RedirectURI)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		// localhost may be resolved to either address
+		return c.RedirectURI, []string{net.JoinHostPort("127.0.0.1", port), net.JoinHostPort("::1", port)}, nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return "", nil, fmt.Errorf("invalid redirect_uri %s: only http callbacks on the loopback interface are supported", c.RedirectURI)
+	}
+	return c.RedirectURI, []string{net.JoinHostPort(host, port)}, nil
+}
+
+// randomString returns a random string usable as PKCE code verifier and
+// state.
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// openBrowser tries to open url in the browser of the user. Errors are
+// ignored, the URL is printed as well.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if cmd.Start() == nil {
+		go cmd.Wait()
+	}
+}
@@ -0,0 +1,43 @@
+package oidc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ClientConfig
+		wantURI   string
+		wantAddrs []string
+		wantErr   bool
+	}{
+		{name: "default", wantURI: "http://127.0.0.1:8080/", wantAddrs: []string{"127.0.0.1:8080"}},
+		{name: "port", config: ClientConfig{RedirectPort: 9000}, wantURI: "http://127.0.0.1:9000/", wantAddrs: []string{"127.0.0.1:9000"}},
+		{name: "uri", config: ClientConfig{RedirectURI: "http://127.0.0.1:8400/callback", RedirectPort: 9000}, wantURI: "http://127.0.0.1:8400/callback", wantAddrs: []string{"127.0.0.1:8400"}},
+		{name: "ipv6", config: ClientConfig{RedirectURI: "http://[::1]:8400/"}, wantURI: "http://[::1]:8400/", wantAddrs: []string{"[::1]:8400"}},
+		{name: "localhost", config: ClientConfig{RedirectURI: "http://localhost:8400/"}, wantURI: "http://localhost:8400/", wantAddrs: []string{"127.0.0.1:8400", "[::1]:8400"}},
+		{name: "default http port", config: ClientConfig{RedirectURI: "http://127.0.0.1/"}, wantURI: "http://127.0.0.1/", wantAddrs: []string{"127.0.0.1:80"}},
+		{name: "https", config: ClientConfig{RedirectURI: "https://127.0.0.1:8400/"}, wantErr: true},
+		{name: "remote host", config: ClientConfig{RedirectURI: "http://example.com:8400/"}, wantErr: true},
+		{name: "private address", config: ClientConfig{RedirectURI: "http://10.0.0.1:8400/"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, addrs, err := tt.config.redirect()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("redirect() = %s, want error", uri)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redirect(): %s", err)
+			}
+			if uri != tt.wantURI || !slices.Equal(addrs, tt.wantAddrs) {
+				t.Errorf("redirect() = %s, %q, want %s, %q", uri, addrs, tt.wantURI, tt.wantAddrs)
+			}
+		})
+	}
+}
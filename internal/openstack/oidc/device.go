@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceAuthorization is the response of the device authorization endpoint,
+// see RFC 8628 section 3.2.
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceAuthorization logs in with the device authorization grant. The user
+// is asked to open the verification URL in a browser and enter the user code,
+// meanwhile the token endpoint is polled until the login is complete.
+func (c *ClientConfig) DeviceAuthorization(ctx context.Context) (*Token, error) {
+	m, err := c.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("identity provider %s does not support the device authorization grant", m.Issuer)
+	}
+
+	var d deviceAuthorization
+	err = c.postForm(ctx, m.DeviceAuthorizationEndpoint, url.Values{"scope": {c.scope()}}, &d)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start device authorization: %w", err)
+	}
+
+	if d.VerificationURIComplete != "" {
+		fmt.Printf("To log in, open %s\nand check that it shows the code %s\n", d.VerificationURIComplete, d.UserCode)
+	} else {
+		fmt.Printf("To log in, open %s\nand enter the code %s\n", d.VerificationURI, d.UserCode)
+	}
+
+	interval := time.Duration(d.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if d.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(d.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	form := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {d.DeviceCode},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("device code expired before the login was completed")
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		t, err := c.requestToken(ctx, m.TokenEndpoint, form)
+		var e *tokenError
+		if errors.As(err, &e) {
+			switch e.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("device authorization failed: %w", err)
+		}
+		return t, nil
+	}
+}
@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Password gets a token with the resource owner password credentials grant,
+// for service accounts that can't log in interactively.
+func (c *ClientConfig) Password(ctx context.Context, username, password string) (*Token, error) {
+	if username == "" || password == "" {
+		return nil, errors.New("username and password are required for the password grant")
+	}
+	m, err := c.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.requestToken(ctx, m.TokenEndpoint, url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {c.scope()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("password grant failed: %w", err)
+	}
+	return t, nil
+}
+
+// ClientCredentials gets a token for the client itself with the client
+// credentials grant.
+func (c *ClientConfig) ClientCredentials(ctx context.Context) (*Token, error) {
+	if c.ClientSecret == "" {
+		return nil, errors.New("client_secret is required for the client credentials grant")
+	}
+	m, err := c.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c.requestToken(ctx, m.TokenEndpoint, url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {c.scope()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client credentials grant failed: %w", err)
+	}
+	return t, nil
+}
+
+// Refresh gets a new token with the refresh token of an earlier login. The
+// refresh token is kept if the identity provider doesn't rotate it.
+func (c *ClientConfig) Refresh(ctx context.Context, t *Token) (*Token, error) {
+	if t.RefreshToken == "" {
+		return nil, errors.New("no refresh token")
+	}
+	m, err := c.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nt, err := c.requestToken(ctx, m.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.RefreshToken},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh token: %w", err)
+	}
+	if nt.RefreshToken == "" {
+		nt.RefreshToken = t.RefreshToken
+	}
+	return nt, nil
+}
@@ -13,7 +13,13 @@ func AuthenticatedClient(ctx context.Context, authopts *AuthOptions) (*gopherclo
 	if err != nil {
 		return nil, err
 	}
+	if authopts.HTTPClient != nil {
+		client.HTTPClient = *authopts.HTTPClient
+	}
 	client.ReauthFunc = nil
+	if authopts.RenewAccessToken != nil {
+		client.ReauthFunc = reauthFunc(client, authopts)
+	}
 
 	keystone, err := openstack.NewIdentityV3(client, gophercloud.EndpointOpts{})
 	if err != nil {
@@ -36,3 +42,36 @@ func AuthenticatedClient(ctx context.Context, authopts *AuthOptions) (*gopherclo
 	}
 	return client, nil
 }
+
+// reauthFunc returns a ReauthFunc which gets a new Keystone token with a
+// renewed access token. Like the ReauthFunc of gophercloud it authenticates
+// with a throw-away copy of the client, which can't reauthenticate itself.
+func reauthFunc(client *gophercloud.ProviderClient, authopts *AuthOptions) func(context.Context) error {
+	tac := *client
+	tac.SetThrowaway(true)
+	tac.ReauthFunc = nil
+
+	return func(ctx context.Context) error {
+		accessToken, err := authopts.RenewAccessToken(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to renew access token: %w", err)
+		}
+
+		if err := tac.SetTokenAndAuthResult(nil); err != nil {
+			return err
+		}
+		keystone, err := openstack.NewIdentityV3(&tac, gophercloud.EndpointOpts{})
+		if err != nil {
+			return err
+		}
+
+		opts := *authopts
+		opts.AccessToken = accessToken
+		opts.UnscopedTokenID = ""
+		if err := tac.SetTokenAndAuthResult(Create(ctx, keystone, &opts)); err != nil {
+			return err
+		}
+		client.CopyTokenFrom(&tac)
+		return nil
+	}
+}
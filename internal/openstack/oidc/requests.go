@@ -2,6 +2,7 @@ package oidc
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
@@ -11,10 +12,14 @@ type AuthOptions struct {
 	AccessToken      string
 	IdentityProvider string
 	IdentityEndpoint string
+	HTTPClient       *http.Client
 	UnscopedTokenID  string
 	Scope            tokens.Scope
 	DomainID         string
 	DomainName       string
+	// RenewAccessToken returns a new access token when the Keystone token
+	// has expired. Without it the client can't reauthenticate.
+	RenewAccessToken func(context.Context) (string, error)
 }
 
 func (opts AuthOptions) ToTokenV3CreateMap(scope map[string]any) (map[string]any, error) {
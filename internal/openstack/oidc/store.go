@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/modzilla99/osssh/internal/state"
+)
+
+// tokenDir returns the directory the tokens of interactive logins are kept
+// in, so later runs don't need to log in again.
+func tokenDir() (string, error) {
+	return state.Dir("tokens")
+}
+
+func tokenPath(cloud string) (string, error) {
+	if cloud == "" {
+		cloud = "default"
+	}
+	dir, err := tokenDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(cloud)+".json"), nil
+}
+
+// LoadToken returns the stored token of the cloud, or nil if there is none.
+func LoadToken(cloud string) (*Token, error) {
+	file, err := tokenPath(cloud)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var t Token
+	if err := json.Unmarshal(content, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveToken stores the token of the cloud. It is only readable by the user.
+func SaveToken(cloud string, t *Token) error {
+	file, err := tokenPath(cloud)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	// concurrent logins must not write to the same temporary file
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClientConfig identifies osssh as a client of the OpenID Connect identity
+// provider.
+type ClientConfig struct {
+	DiscoveryEndpoint string
+	// TokenEndpoint overrides the token endpoint of the discovery document,
+	// it is enough for the non-interactive grants
+	TokenEndpoint string
+	ClientID      string
+	ClientSecret  string
+	Scope         string
+	// RedirectURI is the loopback callback of the authorization code grant,
+	// http://127.0.0.1:<RedirectPort>/ if empty
+	RedirectURI  string
+	RedirectPort int
+	HTTPClient   *http.Client
+}
+
+// Token is the response of the token endpoint.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	ExpiresIn    int       `json:"expires_in,omitempty"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+}
+
+// Expired reports whether the access token has expired or is about to.
+// Tokens without expiry are assumed to be valid.
+func (t *Token) Expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(30*time.Second).After(t.Expiry)
+}
+
+// tokenError is the error response of the token endpoint, see RFC 6749
+// section 5.2.
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// providerMetadata are the parts of the discovery document osssh uses.
+type providerMetadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+func (c *ClientConfig) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *ClientConfig) scope() string {
+	if c.Scope != "" {
+		return c.Scope
+	}
+	return "openid"
+}
+
+// discover fetches the discovery document of the identity provider.
+func (c *ClientConfig) discover(ctx context.Context) (*providerMetadata, error) {
+	if c.DiscoveryEndpoint == "" {
+		return nil, errors.New("neither discovery_endpoint nor access_token_endpoint is set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DiscoveryEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch discovery document: %s", resp.Status)
+	}
+
+	var m providerMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("unable to parse discovery document: %w", err)
+	}
+	return &m, nil
+}
+
+// endpoints returns the endpoints of the identity provider, with the token
+// endpoint overridden by the configuration. The discovery document is only
+// fetched when needed.
+func (c *ClientConfig) endpoints(ctx context.Context) (*providerMetadata, error) {
+	if c.DiscoveryEndpoint == "" && c.TokenEndpoint != "" {
+		return &providerMetadata{Issuer: c.TokenEndpoint, TokenEndpoint: c.TokenEndpoint}, nil
+	}
+	m, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.TokenEndpoint != "" {
+		m.TokenEndpoint = c.TokenEndpoint
+	}
+	return m, nil
+}
+
+// postForm sends a form with the credentials of the client to an endpoint of
+// the identity provider and decodes the JSON response into v. Errors of the
+// identity provider are returned as *tokenError.
+func (c *ClientConfig) postForm(ctx context.Context, endpoint string, form url.Values, v any) error {
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e tokenError
+		if json.Unmarshal(body, &e) == nil && e.Code != "" {
+			return &e
+		}
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// requestToken exchanges a grant for a token at the token endpoint.
+func (c *ClientConfig) requestToken(ctx context.Context, endpoint string, form url.Values) (*Token, error) {
+	var t Token
+	if err := c.postForm(ctx, endpoint, form, &t); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("token endpoint returned no access token")
+	}
+	if t.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return &t, nil
+}
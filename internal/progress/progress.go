@@ -0,0 +1,25 @@
+// Package progress prints the progress messages of osssh, like
+// "Connecting to SSH...Done". Commands whose output is consumed by other
+// programs can redirect or silence them.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Output is where progress messages are written to.
+var Output io.Writer = os.Stdout
+
+func Print(a ...any) {
+	fmt.Fprint(Output, a...)
+}
+
+func Printf(format string, a ...any) {
+	fmt.Fprintf(Output, format, a...)
+}
+
+func Println(a ...any) {
+	fmt.Fprintln(Output, a...)
+}
@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	fingerprintsBegin = "-----BEGIN SSH HOST KEY FINGERPRINTS-----"
+	fingerprintsEnd   = "-----END SSH HOST KEY FINGERPRINTS-----"
+	keysBegin         = "-----BEGIN SSH HOST KEY KEYS-----"
+	keysEnd           = "-----END SSH HOST KEY KEYS-----"
+)
+
+var (
+	fingerprintSHA256 = regexp.MustCompile(`SHA256:[A-Za-z0-9+/]+`)
+	fingerprintMD5    = regexp.MustCompile(`(?:[0-9a-f]{2}:){15}[0-9a-f]{2}`)
+)
+
+// ConsoleHostKeys holds the SSH host keys cloud-init printed to the console
+// of a VM.
+type ConsoleHostKeys struct {
+	Fingerprints []string
+	Keys         []ssh.PublicKey
+}
+
+// ParseConsoleHostKeys extracts the host key fingerprints and host keys
+// cloud-init prints between its markers from the console log of a VM.
+// Console lines are often prefixed (e.g. "ec2: "), so markers and values
+// are searched for anywhere in a line.
+func ParseConsoleHostKeys(console string) (*ConsoleHostKeys, error) {
+	var (
+		hk      ConsoleHostKeys
+		section string
+	)
+
+	s := bufio.NewScanner(strings.NewReader(console))
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.Contains(line, fingerprintsBegin):
+			section = fingerprintsBegin
+			// keys of an earlier boot are no longer valid
+			hk.Fingerprints = nil
+			continue
+		case strings.Contains(line, keysBegin):
+			section = keysBegin
+			hk.Keys = nil
+			continue
+		case strings.Contains(line, fingerprintsEnd), strings.Contains(line, keysEnd):
+			section = ""
+			continue
+		}
+
+		switch section {
+		case fingerprintsBegin:
+			if fp := fingerprintSHA256.FindString(line); fp != "" {
+				hk.Fingerprints = append(hk.Fingerprints, fp)
+			} else if fp := fingerprintMD5.FindString(line); fp != "" {
+				hk.Fingerprints = append(hk.Fingerprints, fp)
+			}
+		case keysBegin:
+			if key := parseConsoleKey(line); key != nil {
+				hk.Keys = append(hk.Keys, key)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(hk.Fingerprints) == 0 {
+		return nil, errors.New("no SSH host key fingerprints found in console output")
+	}
+	return &hk, nil
+}
+
+func parseConsoleKey(line string) ssh.PublicKey {
+	fields := strings.Fields(line)
+	for i := range fields {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+		if err == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the fingerprint of key was printed to the console.
+func (hk *ConsoleHostKeys) Matches(key ssh.PublicKey) bool {
+	return slices.Contains(hk.Fingerprints, ssh.FingerprintSHA256(key)) ||
+		slices.Contains(hk.Fingerprints, ssh.FingerprintLegacyMD5(key))
+}
+
+// Verified returns the host keys from the console whose fingerprints have
+// been printed as well.
+func (hk *ConsoleHostKeys) Verified() []ssh.PublicKey {
+	keys := make([]ssh.PublicKey, 0, len(hk.Keys))
+	for _, key := range hk.Keys {
+		if hk.Matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// HostKeyCallback accepts host keys whose fingerprints were printed to the
+// console.
+func (hk *ConsoleHostKeys) HostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !hk.Matches(key) {
+			return fmt.Errorf("host key %s does not match the fingerprints from the console output", ssh.FingerprintSHA256(key))
+		}
+		return nil
+	}
+}
+
+// ScanHostKey performs an SSH handshake over conn and returns the host key of
+// the server if its fingerprint was printed to the console.
+func (hk *ConsoleHostKeys) ScanHostKey(conn net.Conn) (ssh.PublicKey, error) {
+	defer conn.Close()
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return hk.HostKeyCallback()(hostname, remote, key)
+		},
+	}
+
+	// authentication is expected to fail, the handshake has happened by then
+	c, _, _, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	if err == nil {
+		c.Close()
+	}
+	if hostKey == nil {
+		return nil, fmt.Errorf("unable to get host key: %w", err)
+	}
+	if !hk.Matches(hostKey) {
+		return nil, err
+	}
+	return hostKey, nil
+}
+
+// WriteKnownHosts replaces the entries for alias in the known_hosts file with
+// the given keys.
+func WriteKnownHosts(file, alias string, keys []ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	var lines []string
+
+	content, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for line := range strings.SplitSeq(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && slices.Contains(strings.Split(fields[0], ","), alias) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	for _, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{alias}, key))
+	}
+
+	return os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// consoleBlock prints the keys like cloud-init does, every line prefixed with
+// prefix.
+func consoleBlock(prefix string, fingerprint func(ssh.PublicKey) string, keys ...ssh.PublicKey) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s\n", prefix, fingerprintsBegin)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s256 %s root@vm (ED25519)\n", prefix, fingerprint(k))
+	}
+	fmt.Fprintf(&b, "%s%s\n", prefix, fingerprintsEnd)
+	fmt.Fprintf(&b, "%s%s\n", prefix, keysBegin)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s%s root@vm\n", prefix, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k))))
+	}
+	fmt.Fprintf(&b, "%s%s\n", prefix, keysEnd)
+	return b.String()
+}
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	oldKey, key, other := newHostKey(t), newHostKey(t), newHostKey(t)
+
+	tests := []struct {
+		name     string
+		console  string
+		verified []ssh.PublicKey
+		rejected []ssh.PublicKey
+		wantErr  bool
+	}{
+		{
+			name:     "plain",
+			console:  "[  OK  ] Started cloud-init\n" + consoleBlock("", ssh.FingerprintSHA256, key) + "login: ",
+			verified: []ssh.PublicKey{key},
+			rejected: []ssh.PublicKey{other},
+		},
+		{
+			name:     "prefixed lines",
+			console:  consoleBlock("ec2: ", ssh.FingerprintSHA256, key),
+			verified: []ssh.PublicKey{key},
+			rejected: []ssh.PublicKey{other},
+		},
+		{
+			name:     "md5 fingerprints",
+			console:  consoleBlock("[   12.345678] cloud-init[812]: ", ssh.FingerprintLegacyMD5, key),
+			verified: []ssh.PublicKey{key},
+			rejected: []ssh.PublicKey{other},
+		},
+		{
+			name:     "several keys",
+			console:  consoleBlock("", ssh.FingerprintSHA256, key, other),
+			verified: []ssh.PublicKey{key, other},
+		},
+		{
+			name:     "last boot wins",
+			console:  consoleBlock("", ssh.FingerprintSHA256, oldKey) + "reboot: Restarting system\n" + consoleBlock("", ssh.FingerprintSHA256, key),
+			verified: []ssh.PublicKey{key},
+			rejected: []ssh.PublicKey{oldKey},
+		},
+		{
+			name: "key without fingerprint",
+			console: consoleBlock("", ssh.FingerprintSHA256, key) +
+				keysBegin + "\n" + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(other))) + "\n" + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + "\n" + keysEnd + "\n",
+			verified: []ssh.PublicKey{key},
+			rejected: []ssh.PublicKey{other},
+		},
+		{
+			name:    "fingerprints outside markers",
+			console: "256 " + ssh.FingerprintSHA256(key) + " root@vm (ED25519)\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty console",
+			console: "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hk, err := ParseConsoleHostKeys(tt.console)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseConsoleHostKeys = %+v, want error", hk)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConsoleHostKeys: %s", err)
+			}
+
+			verified := hk.Verified()
+			if len(verified) != len(tt.verified) {
+				t.Fatalf("got %d verified keys, want %d", len(verified), len(tt.verified))
+			}
+			for i, k := range tt.verified {
+				if string(verified[i].Marshal()) != string(k.Marshal()) {
+					t.Errorf("verified key %d = %s, want %s", i, ssh.FingerprintSHA256(verified[i]), ssh.FingerprintSHA256(k))
+				}
+				if err := hk.HostKeyCallback()("vm", nil, k); err != nil {
+					t.Errorf("HostKeyCallback rejected %s: %s", ssh.FingerprintSHA256(k), err)
+				}
+			}
+			for _, k := range tt.rejected {
+				if hk.Matches(k) {
+					t.Errorf("%s matches, want no match", ssh.FingerprintSHA256(k))
+				}
+				if err := hk.HostKeyCallback()("vm", nil, k); err == nil {
+					t.Errorf("HostKeyCallback accepted %s", ssh.FingerprintSHA256(k))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// HostKeyAlias returns the name the host keys of a server are stored under
+// in the known_hosts file of osssh. All VMs are reached on localhost, so the
+// server id is used to tell them apart.
+func HostKeyAlias(serverID string) string {
+	return "osssh-" + serverID
+}
+
+// KnownHostsFile returns the path to the known_hosts file osssh keeps the
+// host keys of VMs in and makes sure its directory exists.
+func KnownHostsFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "osssh")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "known_hosts"), nil
+}
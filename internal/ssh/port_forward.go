@@ -2,22 +2,45 @@ package ssh
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-
-	"golang.org/x/crypto/ssh"
+	"sync/atomic"
 )
 
-func PortForward(ctx context.Context, client *ssh.Client, port int, remoteAddress net.Addr) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
-	if err != nil {
-		return err
-	}
+// Stats counts the bytes transferred through forwards.
+type Stats struct {
+	// In counts the bytes received from the remote side.
+	In atomic.Int64
+	// Out counts the bytes sent to the remote side.
+	Out atomic.Int64
+}
+
+type countingWriter struct {
+	w       io.Writer
+	counter *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(int64(n))
+	return n, err
+}
+
+// ServeDial forwards all connections accepted by listener to the connections
+// returned by dial until ctx is cancelled. Every connection is dialed in its
+// own goroutine, so a slow dial doesn't hold up the others. Connections dial
+// fails for are closed. The transferred bytes are counted in stats if it is
+// not nil.
+func ServeDial(ctx context.Context, listener net.Listener, dial func() (net.Conn, error), stats *Stats) error {
 	defer listener.Close()
 
 	for {
-		var local net.Conn
+		var (
+			local net.Conn
+			err   error
+		)
 		newConn := make(chan struct{})
 		go func() {
 			local, err = listener.Accept()
@@ -29,39 +52,50 @@ func PortForward(ctx context.Context, client *ssh.Client, port int, remoteAddres
 			return fmt.Errorf("context cancelled")
 		case <-newConn:
 			if err != nil {
-				if err.Error() == fmt.Sprintf("accept tcp 127.0.0.1:%d: use of closed network connection", port) {
-					break
+				if errors.Is(err, net.ErrClosed) {
+					return nil
 				}
 				fmt.Println("Error", err)
 				return err
 			}
 		}
 
-		err = handleNewConnection(client, local, remoteAddress)
-		if err != nil {
-			fmt.Println("Error", err)
-			break
-		}
+		go func() {
+			if err := handleNewConnection(local, dial, stats); err != nil {
+				fmt.Println("Error", err)
+			}
+		}()
 	}
-
-	return nil
 }
 
-func handleNewConnection(client *ssh.Client, local net.Conn, remoteAddress net.Addr) error {
-	remote, err := client.Dial(remoteAddress.Network(), remoteAddress.String())
+func handleNewConnection(local net.Conn, dial func() (net.Conn, error), stats *Stats) error {
+	remote, err := dial()
 	if err != nil {
+		local.Close()
 		return err
 	}
 	done := make(chan struct{}, 2)
 
+	var toLocal, toRemote io.Writer = local, remote
+	if stats != nil {
+		toLocal = countingWriter{w: local, counter: &stats.In}
+		toRemote = countingWriter{w: remote, counter: &stats.Out}
+	}
+
 	go func() {
-		io.Copy(local, remote)
+		io.Copy(toLocal, remote)
 		done <- struct{}{}
 	}()
 
 	go func() {
-		io.Copy(remote, local)
+		io.Copy(toRemote, local)
 		done <- struct{}{}
 	}()
+
+	go func() {
+		<-done
+		local.Close()
+		remote.Close()
+	}()
 	return nil
 }
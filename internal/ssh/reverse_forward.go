@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ReverseListen asks the SSH server to listen on a random port on its
+// loopback interface. Connections to it are returned over the SSH connection.
+func ReverseListen(client *ssh.Client) (net.Listener, int, error) {
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to listen on remote host: %w", err)
+	}
+	return listener, listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// ReverseForward accepts connections from the remote listener and forwards
+// them to the local target until ctx is cancelled.
+func ReverseForward(ctx context.Context, listener net.Listener, target string) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		remote, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go func() {
+			defer remote.Close()
+
+			local, err := net.Dial("tcp", target)
+			if err != nil {
+				fmt.Println("Error", err)
+				return
+			}
+			defer local.Close()
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(local, remote)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(remote, local)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
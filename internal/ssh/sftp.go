@@ -0,0 +1,210 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// progress prints the progress of a file transfer on a single line.
+type progress struct {
+	name    string
+	total   int64
+	written int64
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	p.print()
+	return len(b), nil
+}
+
+func (p *progress) print() {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.written * 100 / p.total
+	}
+	fmt.Printf("\r%s %3d%% %s/%s", p.name, percent, FormatBytes(p.written), FormatBytes(p.total))
+}
+
+func (p *progress) done() {
+	p.print()
+	fmt.Println()
+}
+
+// FormatBytes formats a number of bytes with a binary unit.
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func copyWithProgress(dst io.Writer, src io.Reader, name string, size int64) error {
+	p := &progress{name: name, total: size}
+	_, err := io.Copy(dst, io.TeeReader(src, p))
+	p.done()
+	return err
+}
+
+// Download copies the file or directory src on the VM to dst. Modes and
+// modification times are preserved.
+func Download(c *sftp.Client, src, dst string, recursive bool) error {
+	st, err := c.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if lst, err := os.Stat(dst); err == nil && lst.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	if st.IsDir() {
+		if !recursive {
+			return fmt.Errorf("%s is a directory, use -R to copy it", src)
+		}
+		return downloadDir(c, src, dst, st)
+	}
+	return downloadFile(c, src, dst, st)
+}
+
+func downloadDir(c *sftp.Client, src, dst string, st fs.FileInfo) error {
+	// keep the directory writable until its content has been copied
+	if err := os.MkdirAll(dst, 0o700); err != nil {
+		return err
+	}
+
+	entries, err := c.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		s, d := path.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		switch {
+		case e.IsDir():
+			err = downloadDir(c, s, d, e)
+		case e.Mode().IsRegular():
+			err = downloadFile(c, s, d, e)
+		default:
+			fmt.Printf("Skipping %s, not a regular file\n", s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chmod(dst, st.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+func downloadFile(c *sftp.Client, src, dst string, st fs.FileInfo) error {
+	r, err := c.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := copyWithProgress(w, r, src, st.Size()); err != nil {
+		return err
+	}
+	if err := w.Chmod(st.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+// Upload copies the local file or directory src to dst on the VM. Modes and
+// modification times are preserved.
+func Upload(c *sftp.Client, src, dst string, recursive bool) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if rst, err := c.Stat(dst); err == nil && rst.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	if st.IsDir() {
+		if !recursive {
+			return fmt.Errorf("%s is a directory, use -R to copy it", src)
+		}
+		return uploadDir(c, src, dst, st)
+	}
+	return uploadFile(c, src, dst, st)
+}
+
+func uploadDir(c *sftp.Client, src, dst string, st fs.FileInfo) error {
+	if err := c.MkdirAll(dst); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		s, d := filepath.Join(src, e.Name()), path.Join(dst, e.Name())
+		switch {
+		case info.IsDir():
+			err = uploadDir(c, s, d, info)
+		case info.Mode().IsRegular():
+			err = uploadFile(c, s, d, info)
+		default:
+			fmt.Printf("Skipping %s, not a regular file\n", s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.Chmod(dst, st.Mode().Perm()); err != nil {
+		return err
+	}
+	return c.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+func uploadFile(c *sftp.Client, src, dst string, st fs.FileInfo) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := c.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := copyWithProgress(w, r, src, st.Size()); err != nil {
+		return err
+	}
+	if err := w.Chmod(st.Mode().Perm()); err != nil {
+		return err
+	}
+	return c.Chtimes(dst, st.ModTime(), st.ModTime())
+}
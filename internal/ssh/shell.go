@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Shell starts an interactive session on the client. If command is empty a
+// login shell is started. The local terminal is put into raw mode and its
+// size is kept in sync. It returns the exit code of the remote command.
+func Shell(client *ssh.Client, command string) (int, error) {
+	s, err := GetSession(client)
+	if err != nil {
+		return 1, err
+	}
+	defer s.Close()
+
+	s.Stdin = os.Stdin
+	s.Stdout = os.Stdout
+	s.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			return 1, err
+		}
+
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm-256color"
+		}
+		err = s.RequestPty(termType, height, width, ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		})
+		if err != nil {
+			return 1, fmt.Errorf("unable to request pty: %w", err)
+		}
+
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return 1, err
+		}
+		defer term.Restore(fd, state)
+
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		defer func() {
+			// no signal is delivered after Stop, closing ends the goroutine
+			signal.Stop(resize)
+			close(resize)
+		}()
+		go func() {
+			for range resize {
+				if w, h, err := term.GetSize(fd); err == nil {
+					s.WindowChange(h, w)
+				}
+			}
+		}()
+	}
+
+	if command == "" {
+		err = s.Shell()
+	} else {
+		err = s.Start(command)
+	}
+	if err != nil {
+		return 1, err
+	}
+
+	err = s.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return 255, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -3,8 +3,8 @@ package ssh
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
@@ -51,9 +51,9 @@ func NewClient(hostname string, username string) (*ssh.Client, error) {
 	// Connect
 	client, err := ssh.Dial("tcp", net.JoinHostPort(hostname, "22"), config)
 	if err != nil {
-		if err.Error() == "ssh: handshake failed: knownhosts: key is unknown" {
-			fmt.Printf("Error\n\nCould not verify host key of %s, please add it to your known_hosts file by connecting to it with SSH\n", hostname)
-			os.Exit(1)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil, fmt.Errorf("could not verify host key of %s, please add it to your known_hosts file by connecting to it with SSH", hostname)
 		}
 		return nil, err
 	}
@@ -105,7 +105,7 @@ func WriteFile(client *ssh.Client, fileName string, file []byte) error {
 func ConnectSSHAgentSock() (*net.Conn, error) {
 	agentPath, exist := os.LookupEnv("SSH_AUTH_SOCK")
 	if !exist {
-		log.Fatalln("SSH-Agent is not running, unable to authenticate...")
+		return nil, errors.New("SSH-Agent is not running, unable to authenticate")
 	}
 	// Connect to ssh-agent socket
 	agentSock, err := net.Dial("unix", agentPath)
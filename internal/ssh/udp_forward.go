@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	udpFrameHeaderSize = 6
+	udpFlowTimeout     = 60 * time.Second
+)
+
+type udpFlow struct {
+	addr     net.Addr
+	lastSeen time.Time
+}
+
+type udpFlows struct {
+	mu     sync.Mutex
+	nextID uint32
+	byAddr map[string]uint32
+	byID   map[uint32]*udpFlow
+}
+
+func (f *udpFlows) get(addr net.Addr) uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id, ok := f.byAddr[addr.String()]
+	if !ok {
+		f.nextID++
+		id = f.nextID
+		f.byAddr[addr.String()] = id
+		f.byID[id] = &udpFlow{addr: addr}
+	}
+	f.byID[id].lastSeen = time.Now()
+	return id
+}
+
+func (f *udpFlows) lookup(id uint32) (net.Addr, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flow, ok := f.byID[id]
+	if !ok {
+		return nil, false
+	}
+	flow.lastSeen = time.Now()
+	return flow.addr, true
+}
+
+func (f *udpFlows) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, flow := range f.byID {
+		if time.Since(flow.lastSeen) > udpFlowTimeout {
+			delete(f.byAddr, flow.addr.String())
+			delete(f.byID, id)
+		}
+	}
+}
+
+// udpFrame frames a datagram for the relay stream: the 4 byte flow id and 2
+// byte payload length, both big endian, followed by the payload.
+func udpFrame(id uint32, payload []byte) []byte {
+	frame := make([]byte, udpFrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, id)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(payload)))
+	copy(frame[udpFrameHeaderSize:], payload)
+	return frame
+}
+
+// readUDPFrame reads a datagram framed by udpFrame from the relay stream.
+func readUDPFrame(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, udpFrameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[4:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(header), payload, nil
+}
+
+type udpRelay struct {
+	io.Writer
+	io.Reader
+	sess *ssh.Session
+}
+
+func (r *udpRelay) Close() error {
+	return r.sess.Close()
+}
+
+// UDPRelay starts relayCommand on the SSH server and returns its stdin and
+// stdout as the stream framed datagrams are exchanged over.
+func UDPRelay(client *ssh.Client, relayCommand string) (io.ReadWriteCloser, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open session: %w", err)
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	err = sess.Start(relayCommand)
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("cannot start udp relay: %w", err)
+	}
+	return &udpRelay{Writer: stdin, Reader: stdout, sess: sess}, nil
+}
+
+// UDPForward listens for datagrams on the local address and frames them over
+// the relay stream, see UDPRelay. Every local client gets its own flow, so
+// replies are returned to the client that sent the request. Payload bytes
+// are counted in stats if it is not nil. The relay is closed on return.
+func UDPForward(ctx context.Context, relay io.ReadWriteCloser, address string, stats *Stats) error {
+	defer relay.Close()
+
+	local, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	flows := &udpFlows{
+		byAddr: map[string]uint32{},
+		byID:   map[uint32]*udpFlow{},
+	}
+	errChan := make(chan error, 3)
+
+	// remote -> local
+	go func() {
+		for {
+			id, payload, err := readUDPFrame(relay)
+			if err != nil {
+				errChan <- fmt.Errorf("udp relay closed: %w", err)
+				return
+			}
+
+			addr, ok := flows.lookup(id)
+			if !ok {
+				continue
+			}
+			if n, _ := local.WriteTo(payload, addr); stats != nil {
+				stats.In.Add(int64(n))
+			}
+		}
+	}()
+
+	// local -> remote
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := local.ReadFrom(buf)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			if _, err := relay.Write(udpFrame(flows.get(addr), buf[:n])); err != nil {
+				errChan <- fmt.Errorf("unable to write to udp relay: %w", err)
+				return
+			}
+			if stats != nil {
+				stats.Out.Add(int64(n))
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(udpFlowTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errChan:
+			return err
+		case <-ticker.C:
+			flows.expire()
+		}
+	}
+}
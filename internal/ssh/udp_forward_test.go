@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestUDPFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      uint32
+		payload []byte
+	}{
+		{name: "empty", id: 1, payload: []byte{}},
+		{name: "dns query", id: 42, payload: []byte("\x12\x34\x01\x00\x00\x01")},
+		{name: "max id", id: 0xffffffff, payload: []byte("x")},
+		{name: "max payload", id: 7, payload: bytes.Repeat([]byte{0xab}, 65535)},
+	}
+
+	var stream bytes.Buffer
+	for _, tt := range tests {
+		stream.Write(udpFrame(tt.id, tt.payload))
+	}
+	for _, tt := range tests {
+		id, payload, err := readUDPFrame(&stream)
+		if err != nil {
+			t.Fatalf("%s: readUDPFrame: %s", tt.name, err)
+		}
+		if id != tt.id {
+			t.Errorf("%s: flow id = %d, want %d", tt.name, id, tt.id)
+		}
+		if !bytes.Equal(payload, tt.payload) {
+			t.Errorf("%s: payload = %x, want %x", tt.name, payload, tt.payload)
+		}
+	}
+	if _, _, err := readUDPFrame(&stream); !errors.Is(err, io.EOF) {
+		t.Errorf("readUDPFrame at end of stream: %v, want EOF", err)
+	}
+}
+
+func TestUDPFrameHeader(t *testing.T) {
+	frame := udpFrame(0x01020304, []byte("hi"))
+	want := []byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x02, 'h', 'i'}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("udpFrame = %x, want %x", frame, want)
+	}
+}
+
+func TestReadUDPFrameTruncated(t *testing.T) {
+	frame := udpFrame(1, []byte("hello"))
+	for _, n := range []int{3, udpFrameHeaderSize, len(frame) - 1} {
+		if _, _, err := readUDPFrame(bytes.NewReader(frame[:n])); !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Errorf("readUDPFrame of %d bytes: %v, want EOF", n, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// VMClientOpts configures the SSH connection to a VM.
+type VMClientOpts struct {
+	Username string
+	// HostKeyAlias is the name the host keys of the VM are checked against.
+	HostKeyAlias    string
+	HostKeyCallback ssh.HostKeyCallback
+	// KeyName and PublicKey identify the Nova keypair of the VM. Agent keys
+	// matching it are tried first.
+	KeyName   string
+	PublicKey string
+}
+
+// NewVMClient opens an SSH connection to a VM over conn, which usually is a
+// connection through the netns-proxy on the hypervisor. It authenticates with
+// the keys of the SSH agent.
+func NewVMClient(conn net.Conn, opts VMClientOpts) (*ssh.Client, error) {
+	s, err := ConnectSSHAgentSock()
+	if err != nil {
+		return nil, err
+	}
+	defer (*s).Close()
+	a, err := ConnectSSHAgent(s)
+	if err != nil {
+		return nil, err
+	}
+
+	pkAuth, err := GetPrivateKeysFor(a, opts.KeyName, opts.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            opts.Username,
+		Auth:            []ssh.AuthMethod{pkAuth},
+		HostKeyCallback: opts.HostKeyCallback,
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, net.JoinHostPort(opts.HostKeyAlias, "22"), config)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// GetPrivateKeysFor returns the keys of the agent like GetPrivateKeys, but
+// keys matching the Nova keypair given by keyName or publicKey come first.
+func GetPrivateKeysFor(a agent.ExtendedAgent, keyName, publicKey string) (ssh.AuthMethod, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	signers, err := a.Signers()
+	if err != nil {
+		return nil, err
+	}
+
+	var want []byte
+	if publicKey != "" {
+		if pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err == nil {
+			want = pk.Marshal()
+		}
+	}
+
+	matching := func(s ssh.Signer) bool {
+		blob := s.PublicKey().Marshal()
+		if want != nil && bytes.Equal(blob, want) {
+			return true
+		}
+		for _, k := range keys {
+			if keyName != "" && k.Comment == keyName && bytes.Equal(k.Blob, blob) {
+				return true
+			}
+		}
+		return false
+	}
+
+	sorted := make([]ssh.Signer, 0, len(signers))
+	for _, s := range signers {
+		if matching(s) {
+			sorted = append(sorted, s)
+		}
+	}
+	for _, s := range signers {
+		if !matching(s) {
+			sorted = append(sorted, s)
+		}
+	}
+	return ssh.PublicKeys(sorted...), nil
+}
+
+// knownHostsMu serializes writes to known_hosts files.
+var knownHostsMu sync.Mutex
+
+// TrustOnFirstUse returns a host key callback checking against the
+// known_hosts file. Unknown host keys are added to it if confirm accepts
+// them, a nil confirm rejects them.
+func TrustOnFirstUse(file string, confirm func(host string, key ssh.PublicKey) bool) (ssh.HostKeyCallback, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		host := knownhosts.Normalize(hostname)
+		if confirm == nil || !confirm(host, key) {
+			return fmt.Errorf("host key verification failed for %s", host)
+		}
+
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		kh, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer kh.Close()
+		_, err = fmt.Fprintln(kh, knownhosts.Line([]string{host}, key))
+		return err
+	}, nil
+}
+
+// ConfirmHostKey asks the user whether to trust an unknown host key.
+func ConfirmHostKey(host string, key ssh.PublicKey) bool {
+	fmt.Printf("The authenticity of host '%s' can't be established.\n", host)
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+
+	answer, _ := readLine(os.Stdin)
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// readLine reads a line from r byte by byte, unlike a bufio.Reader it
+// leaves what follows the line to the shell started after the prompt.
+func readLine(r io.Reader) (string, error) {
+	var (
+		line []byte
+		b    [1]byte
+	)
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// AcceptHostKey trusts every unknown host key.
+func AcceptHostKey(host string, key ssh.PublicKey) bool {
+	return true
+}
@@ -0,0 +1,24 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	r := strings.NewReader("yes\nls -l\n")
+	line, err := readLine(r)
+	if err != nil || line != "yes" {
+		t.Fatalf("readLine = %q, %v, want yes", line, err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "ls -l\n" {
+		t.Errorf("left %q for the shell, want %q", rest, "ls -l\n")
+	}
+
+	line, err = readLine(strings.NewReader("no"))
+	if err != io.EOF || line != "no" {
+		t.Errorf("readLine without newline = %q, %v, want no, EOF", line, err)
+	}
+}
@@ -0,0 +1,27 @@
+// Package state locates the per-user directory osssh keeps its state in,
+// like the tokens of interactive logins and the control sockets of tunnels.
+package state
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Dir returns the directory name in $XDG_STATE_HOME/osssh, or
+// ~/.local/state/osssh, and makes sure it exists. It is only accessible by
+// the user.
+func Dir(name string) (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	dir = filepath.Join(dir, "osssh", name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,273 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/modzilla99/osssh/types/generic"
+)
+
+// OpenFunc brings up the tunnel of a Lazy. release is called once the tunnel
+// has been torn down again with the error it failed with, if any.
+type OpenFunc func(ctx context.Context) (t *Tunnel, release func(err error), err error)
+
+var errLazyClosed = errors.New("tunnel closed")
+
+// Lazy is a tunnel that is brought up by the first connection made through
+// it and torn down again once no connection has been open for the idle
+// timeout, or once it failed.
+type Lazy struct {
+	ctx         context.Context
+	open        OpenFunc
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	t        *Tunnel
+	cancel   context.CancelFunc
+	release  func(err error)
+	opening  *flight
+	forwards map[string]*lazyForward
+	active   int
+	idle     *time.Timer
+	closed   bool
+}
+
+// flight is a bring-up in progress. Connections made meanwhile wait for done
+// and share its err instead of bringing it up again.
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+type lazyForward struct {
+	flight
+	addr generic.AddressPort
+}
+
+// NewLazy returns a tunnel brought up by open on demand. Tunnels are opened
+// with a context derived from ctx.
+func NewLazy(ctx context.Context, open OpenFunc, idleTimeout time.Duration) *Lazy {
+	return &Lazy{
+		ctx:         ctx,
+		open:        open,
+		idleTimeout: idleTimeout,
+		forwards:    map[string]*lazyForward{},
+	}
+}
+
+// Dial opens a connection to remotePort of the server, bringing up the
+// tunnel and the forward to the port first if needed. The lock is not held
+// while they are brought up, so other connections are not blocked by it.
+func (l *Lazy) Dial(protocol string, remotePort int) (net.Conn, error) {
+	t, err := l.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := l.forward(t, protocol, remotePort)
+	if err != nil {
+		l.done()
+		return nil, err
+	}
+	conn, err := t.Dial(addr)
+	if err != nil {
+		l.done()
+		return nil, err
+	}
+	return &lazyConn{Conn: conn, l: l}, nil
+}
+
+// acquire returns the tunnel, bringing it up first if needed. It counts as
+// an open connection until done is called.
+func (l *Lazy) acquire() (*Tunnel, error) {
+	l.mu.Lock()
+	for l.t == nil {
+		if l.closed {
+			l.mu.Unlock()
+			return nil, errLazyClosed
+		}
+
+		if f := l.opening; f != nil {
+			l.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-l.ctx.Done():
+				return nil, l.ctx.Err()
+			}
+			if f.err != nil {
+				return nil, f.err
+			}
+			l.mu.Lock()
+			continue
+		}
+
+		f := &flight{done: make(chan struct{})}
+		l.opening = f
+		l.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(l.ctx)
+		t, release, err := l.open(ctx)
+
+		l.mu.Lock()
+		l.opening = nil
+		if err == nil && l.closed {
+			// Close is waiting for the bring-up to tear it down
+			l.mu.Unlock()
+			cancel()
+			release(t.Wait())
+			f.err = errLazyClosed
+			close(f.done)
+			return nil, f.err
+		}
+		if err != nil {
+			cancel()
+			f.err = err
+			close(f.done)
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.t, l.cancel, l.release = t, cancel, release
+		close(f.done)
+		go l.watch(t)
+	}
+
+	if l.idle != nil {
+		l.idle.Stop()
+	}
+	l.active++
+	t := l.t
+	l.mu.Unlock()
+	return t, nil
+}
+
+// forward returns the address of the forward to remotePort, starting it
+// first if needed.
+func (l *Lazy) forward(t *Tunnel, protocol string, remotePort int) (generic.AddressPort, error) {
+	key := protocol + "/" + strconv.Itoa(remotePort)
+
+	l.mu.Lock()
+	f, ok := l.forwards[key]
+	if ok {
+		l.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-t.Done():
+			return generic.AddressPort{}, errors.New("tunnel closed while forwarding")
+		}
+		return f.addr, f.err
+	}
+	f = &lazyForward{flight: flight{done: make(chan struct{})}}
+	l.forwards[key] = f
+	forwards := l.forwards
+	l.mu.Unlock()
+
+	f.addr, f.err = t.Forward(protocol, remotePort)
+	close(f.done)
+	if f.err != nil {
+		// the next connection tries again
+		l.mu.Lock()
+		if forwards[key] == f {
+			delete(forwards, key)
+		}
+		l.mu.Unlock()
+	}
+	return f.addr, f.err
+}
+
+// done counts a connection as closed and starts the idle timer if it was
+// the last one.
+func (l *Lazy) done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.arm()
+}
+
+// watch tears the tunnel down once a netns-proxy of it failed, the next
+// connection brings it up again.
+func (l *Lazy) watch(t *Tunnel) {
+	<-t.Done()
+
+	l.mu.Lock()
+	var down func()
+	if l.t == t {
+		down = l.detach()
+	}
+	l.mu.Unlock()
+	if down != nil {
+		down()
+	}
+}
+
+// Close tears the tunnel down if it is up or being brought up.
+func (l *Lazy) Close() {
+	l.mu.Lock()
+	l.closed = true
+	if l.idle != nil {
+		l.idle.Stop()
+	}
+	opening := l.opening
+	var down func()
+	if l.t != nil {
+		down = l.detach()
+	}
+	l.mu.Unlock()
+
+	if opening != nil {
+		<-opening.done
+	}
+	if down != nil {
+		down()
+	}
+}
+
+// arm starts the idle timer if no connection is open.
+func (l *Lazy) arm() {
+	if l.active > 0 {
+		return
+	}
+	if l.idle != nil {
+		l.idle.Stop()
+	}
+	l.idle = time.AfterFunc(l.idleTimeout, func() {
+		l.mu.Lock()
+		var down func()
+		if l.active == 0 && l.t != nil {
+			down = l.detach()
+		}
+		l.mu.Unlock()
+		if down != nil {
+			down()
+		}
+	})
+}
+
+// detach takes the tunnel out of l and returns the function tearing it down,
+// which is called without holding the lock as the netns-proxies take a while
+// to shut down. l.mu has to be locked.
+func (l *Lazy) detach() func() {
+	t, cancel, release := l.t, l.cancel, l.release
+	l.t, l.cancel, l.release = nil, nil, nil
+	l.forwards = map[string]*lazyForward{}
+	return func() {
+		cancel()
+		release(t.Wait())
+	}
+}
+
+// lazyConn starts the idle timer of its Lazy when the last connection is
+// closed.
+type lazyConn struct {
+	net.Conn
+	l    *Lazy
+	once sync.Once
+}
+
+func (c *lazyConn) Close() error {
+	c.once.Do(c.l.done)
+	return c.Conn.Close()
+}
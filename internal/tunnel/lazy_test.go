@@ -0,0 +1,180 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// fakeOpen brings up tunnels without a hypervisor once unblock is closed.
+// fail makes the netns-proxy of the current tunnel fail.
+type fakeOpen struct {
+	unblock  chan struct{}
+	opened   atomic.Int32
+	released chan error
+	fail     chan error
+}
+
+func newFakeOpen() *fakeOpen {
+	return &fakeOpen{
+		unblock:  make(chan struct{}),
+		released: make(chan error, 10),
+		fail:     make(chan error, 1),
+	}
+}
+
+func (o *fakeOpen) open(ctx context.Context) (*Tunnel, func(error), error) {
+	select {
+	case <-o.unblock:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	o.opened.Add(1)
+
+	group, gctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		select {
+		case err := <-o.fail:
+			return err
+		case <-gctx.Done():
+			return nil
+		}
+	})
+	return &Tunnel{ctx: gctx, group: group}, func(err error) { o.released <- err }, nil
+}
+
+func TestLazyOpensOnce(t *testing.T) {
+	o := newFakeOpen()
+	l := NewLazy(context.Background(), o.open, time.Hour)
+	defer l.Close()
+
+	var (
+		wg      sync.WaitGroup
+		tunnels [5]*Tunnel
+	)
+	for i := range tunnels {
+		wg.Go(func() {
+			tun, err := l.acquire()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tunnels[i] = tun
+		})
+	}
+
+	// the lock is not held while the tunnel is brought up
+	time.Sleep(50 * time.Millisecond)
+	l.mu.Lock()
+	active := l.active
+	l.mu.Unlock()
+	if active != 0 {
+		t.Fatalf("%d connections active before the tunnel is up", active)
+	}
+
+	close(o.unblock)
+	wg.Wait()
+	if n := o.opened.Load(); n != 1 {
+		t.Errorf("opened %d tunnels, want 1", n)
+	}
+	for _, tun := range tunnels {
+		if tun != tunnels[0] {
+			t.Error("connections got different tunnels")
+		}
+	}
+}
+
+func TestLazyReopensFailedTunnel(t *testing.T) {
+	o := newFakeOpen()
+	close(o.unblock)
+	l := NewLazy(context.Background(), o.open, time.Hour)
+	defer l.Close()
+
+	first, err := l.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.done()
+
+	failed := errors.New("netns-proxy exited")
+	o.fail <- failed
+	select {
+	case err := <-o.released:
+		if !errors.Is(err, failed) {
+			t.Errorf("released with %v, want %v", err, failed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed tunnel not torn down")
+	}
+
+	second, err := l.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.done()
+	if second == first || o.opened.Load() != 2 {
+		t.Errorf("failed tunnel not brought up again, opened %d", o.opened.Load())
+	}
+}
+
+func TestLazyIdleTimeout(t *testing.T) {
+	o := newFakeOpen()
+	close(o.unblock)
+	l := NewLazy(context.Background(), o.open, 10*time.Millisecond)
+	defer l.Close()
+
+	if _, err := l.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-o.released:
+		t.Fatal("torn down while a connection is open")
+	default:
+	}
+
+	l.done()
+	select {
+	case err := <-o.released:
+		if err != nil {
+			t.Errorf("released with %v after idle timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle tunnel not torn down")
+	}
+}
+
+func TestLazyCloseWhileOpening(t *testing.T) {
+	o := newFakeOpen()
+	l := NewLazy(context.Background(), o.open, time.Hour)
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := l.acquire()
+		acquired <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(o.unblock)
+
+	<-closed
+	select {
+	case <-o.released:
+	default:
+		t.Error("Close returned before the tunnel brought up meanwhile was torn down")
+	}
+	if err := <-acquired; !errors.Is(err, errLazyClosed) {
+		t.Errorf("acquire = %v, want %v", err, errLazyClosed)
+	}
+}
@@ -0,0 +1,524 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/modzilla99/osssh/internal/netnsproxy"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// masterRequest starts every connection to the control socket of a master.
+// Depending on Op the connection is closed after the response, kept open or
+// carries a stream afterwards:
+//
+//	forward  share a netns-proxy to Address:Port in NetworkID until the connection is closed, returns the Port on the hypervisor
+//	dial     stream to the netns-proxy on Port
+//	relay    stream of framed datagrams to the udp netns-proxy on Port
+//	reverse  listen on Address:Port in NetworkID until the connection is closed, returns an ID
+//	accept   stream of the next connection to the reverse forward ID
+//	attach   keep the master alive until the connection is closed
+//	ping     check the master is alive
+//	exit     shut the master down
+type masterRequest struct {
+	Op        string `json:"op"`
+	NetworkID string `json:"network_id,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+type masterResponse struct {
+	Port  int    `json:"port,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// master shares one connection to a hypervisor between the osssh processes
+// attached to it. Network namespaces are looked up only once, forwards are
+// shared by the processes using them and stopped when the last one is gone.
+type master struct {
+	client *gossh.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	tunnels   map[string]*masterTunnel
+	listeners map[string]net.Listener
+	nextID    int
+	active    int
+	lastUsed  time.Time
+}
+
+type masterTunnel struct {
+	mu       sync.Mutex
+	t        *Tunnel
+	forwards map[string]*masterForward
+}
+
+// masterForward is a netns-proxy of a masterTunnel, refs counts the
+// connections of the processes using it.
+type masterForward struct {
+	t      *Tunnel
+	port   int
+	refs   int
+	cancel context.CancelFunc
+}
+
+// ServeMaster answers the requests of attached osssh processes on listener
+// using the connection c to the hypervisor, on which netnsproxy.Setup has
+// been run already. It returns once ctx is cancelled, the connection to the
+// hypervisor is lost, an exit is requested or no process has been attached
+// for idleTimeout. All netns-proxies are shut down on return.
+func ServeMaster(ctx context.Context, c *gossh.Client, listener net.Listener, idleTimeout time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := &master{
+		client:    c,
+		ctx:       ctx,
+		cancel:    cancel,
+		tunnels:   map[string]*masterTunnel{},
+		listeners: map[string]net.Listener{},
+		lastUsed:  time.Now(),
+	}
+
+	connClosed := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(connClosed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+				return
+			case <-connClosed:
+				cancel()
+			case <-ticker.C:
+				if m.idle() > idleTimeout {
+					cancel()
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		m.attach(1)
+		wg.Go(func() {
+			defer m.attach(-1)
+			m.handle(conn)
+		})
+	}
+
+	cancel()
+	wg.Wait()
+	for _, mt := range m.tunnels {
+		if mt.t != nil {
+			mt.t.Wait()
+		}
+	}
+
+	select {
+	case <-connClosed:
+		return errors.New("connection to hypervisor closed")
+	default:
+		return nil
+	}
+}
+
+func (m *master) attach(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active += n
+	m.lastUsed = time.Now()
+}
+
+// idle returns how long no process has been attached.
+func (m *master) idle() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active > 0 {
+		return 0
+	}
+	return time.Since(m.lastUsed)
+}
+
+func (m *master) handle(conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(m.ctx, func() { conn.Close() })
+	defer stop()
+
+	// nothing is sent before the response, so the decoder buffers no more
+	// than the request
+	var req masterRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	switch req.Op {
+	case "ping":
+		reply(conn, masterResponse{})
+	case "attach":
+		// held open by attached processes, the master is not idle until
+		// they close it
+		if reply(conn, masterResponse{}) == nil {
+			io.Copy(io.Discard, conn)
+		}
+	case "exit":
+		reply(conn, masterResponse{})
+		m.cancel()
+	case "forward":
+		m.forward(conn, req)
+	case "dial":
+		protocol := req.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		remote, err := m.client.Dial(protocol, net.JoinHostPort("127.0.0.1", strconv.Itoa(req.Port)))
+		if err != nil {
+			reply(conn, masterResponse{Error: err.Error()})
+			return
+		}
+		if reply(conn, masterResponse{}) == nil {
+			pipe(conn, remote)
+		}
+	case "relay":
+		cmd, err := netnsproxy.UDPRelayCommand(req.Port)
+		if err != nil {
+			reply(conn, masterResponse{Error: err.Error()})
+			return
+		}
+		relay, err := ssh.UDPRelay(m.client, cmd)
+		if err != nil {
+			reply(conn, masterResponse{Error: err.Error()})
+			return
+		}
+		if reply(conn, masterResponse{}) == nil {
+			pipe(conn, relay)
+		}
+	case "reverse":
+		m.reverse(conn, req)
+	case "accept":
+		m.mu.Lock()
+		listener, ok := m.listeners[req.ID]
+		m.mu.Unlock()
+		if !ok {
+			reply(conn, masterResponse{Error: "no reverse forward " + req.ID})
+			return
+		}
+		remote, err := listener.Accept()
+		if err != nil {
+			reply(conn, masterResponse{Error: err.Error()})
+			return
+		}
+		if reply(conn, masterResponse{}) == nil {
+			pipe(conn, remote)
+		}
+	default:
+		reply(conn, masterResponse{Error: "unknown op " + req.Op})
+	}
+}
+
+// tunnel returns the tunnel to the server at address in the network, looking
+// up the namespace only for the first request.
+func (m *master) tunnel(networkID, address string) (*masterTunnel, error) {
+	key := networkID + "/" + address
+
+	m.mu.Lock()
+	mt, ok := m.tunnels[key]
+	if !ok {
+		mt = &masterTunnel{forwards: map[string]*masterForward{}}
+		m.tunnels[key] = mt
+	}
+	m.mu.Unlock()
+
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.t != nil {
+		return mt, nil
+	}
+
+	t, err := New(m.ctx, m.client, &openstack.Info{NetworkID: networkID, IPAddress: address})
+	if err != nil {
+		return nil, err
+	}
+	mt.t = t
+	return mt, nil
+}
+
+// forward replies with the port of the netns-proxy to the server, starting it
+// if no other process uses it. It is stopped once the last connection asking
+// for it is closed.
+func (m *master) forward(conn net.Conn, req masterRequest) {
+	mt, err := m.tunnel(req.NetworkID, req.Address)
+	if err != nil {
+		reply(conn, masterResponse{Error: err.Error()})
+		return
+	}
+
+	key := req.Protocol + "/" + strconv.Itoa(req.Port)
+	mt.mu.Lock()
+	f, ok := mt.forwards[key]
+	if ok {
+		select {
+		case <-f.t.Done():
+			// the netns-proxy failed, start a new one
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		ctx, cancel := context.WithCancel(m.ctx)
+		t := mt.t.derive(ctx)
+		addr, err := t.Forward(req.Protocol, req.Port)
+		if err != nil {
+			mt.mu.Unlock()
+			cancel()
+			t.Wait()
+			reply(conn, masterResponse{Error: err.Error()})
+			return
+		}
+		f = &masterForward{t: t, port: addr.Port, cancel: cancel}
+		mt.forwards[key] = f
+	}
+	f.refs++
+	mt.mu.Unlock()
+
+	defer func() {
+		mt.mu.Lock()
+		f.refs--
+		last := f.refs == 0
+		if last {
+			f.cancel()
+			if mt.forwards[key] == f {
+				delete(mt.forwards, key)
+			}
+		}
+		mt.mu.Unlock()
+		if last {
+			f.t.Wait()
+		}
+	}()
+
+	if reply(conn, masterResponse{Port: f.port}) != nil {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-f.t.Done():
+	}
+}
+
+// reverse sets up a reverse forward that is removed once the requesting
+// process closes the connection.
+func (m *master) reverse(conn net.Conn, req masterRequest) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	t, err := New(ctx, m.client, &openstack.Info{NetworkID: req.NetworkID})
+	if err != nil {
+		reply(conn, masterResponse{Error: err.Error()})
+		return
+	}
+	defer t.Wait()
+
+	listener, err := t.Reverse(req.Address, req.Port)
+	if err != nil {
+		reply(conn, masterResponse{Error: err.Error()})
+		return
+	}
+	defer listener.Close()
+
+	m.mu.Lock()
+	m.nextID++
+	id := strconv.Itoa(m.nextID)
+	m.listeners[id] = listener
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.listeners, id)
+		m.mu.Unlock()
+	}()
+
+	if reply(conn, masterResponse{ID: id}) != nil {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-t.Done():
+	}
+}
+
+func reply(conn net.Conn, resp masterResponse) error {
+	return json.NewEncoder(conn).Encode(resp)
+}
+
+// pipe copies between a and b until one side is done and closes both.
+func pipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// bufferedConn reads what has been buffered while decoding the response
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// masterCall sends req to the master listening on sock and returns the
+// connection for the stream following the response.
+func masterCall(sock string, req masterRequest) (net.Conn, masterResponse, error) {
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, masterResponse{}, err
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		conn.Close()
+		return nil, masterResponse{}, err
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, masterResponse{}, fmt.Errorf("master closed the connection: %w", err)
+	}
+	var resp masterResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		conn.Close()
+		return nil, masterResponse{}, err
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, resp, errors.New(resp.Error)
+	}
+	return &bufferedConn{Conn: conn, r: r}, resp, nil
+}
+
+// PingMaster checks whether a master is listening on sock.
+func PingMaster(sock string) error {
+	conn, _, err := masterCall(sock, masterRequest{Op: "ping"})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// StopMaster asks the master listening on sock to exit.
+func StopMaster(sock string) error {
+	conn, _, err := masterCall(sock, masterRequest{Op: "exit"})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Attach returns a tunnel for the server going through the master listening
+// on sock instead of a connection of its own. Its Client is nil. The tunnel
+// fails if the master exits.
+func Attach(ctx context.Context, info *openstack.Info, sock string) (*Tunnel, error) {
+	conn, _, err := masterCall(sock, masterRequest{Op: "attach"})
+	if err != nil {
+		return nil, err
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		io.Copy(io.Discard, conn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errors.New("master exited")
+	})
+	return &Tunnel{
+		Info:   info,
+		master: sock,
+		ctx:    ctx,
+		group:  group,
+	}, nil
+}
+
+// masterListener accepts the connections to a reverse forward of a master.
+type masterListener struct {
+	sock string
+	id   string
+	ctrl net.Conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (l *masterListener) Accept() (net.Conn, error) {
+	conn, _, err := masterCall(l.sock, masterRequest{Op: "accept", ID: l.id})
+	if err != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.closed {
+			return nil, net.ErrClosed
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (l *masterListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	return l.ctrl.Close()
+}
+
+func (l *masterListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.sock, Net: "unix"}
+}
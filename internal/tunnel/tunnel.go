@@ -0,0 +1,314 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	utils "github.com/modzilla99/osssh/internal/general"
+	"github.com/modzilla99/osssh/internal/netnsproxy"
+	openstack "github.com/modzilla99/osssh/internal/openstack/client"
+	"github.com/modzilla99/osssh/internal/progress"
+	"github.com/modzilla99/osssh/internal/ssh"
+	"github.com/modzilla99/osssh/types/generic"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// Tunnel is an SSH connection to the hypervisor of a server together with the
+// network namespace the server is attached to. Netns-proxies started through
+// it run until the context passed to Open is cancelled. Tunnels returned by
+// Attach use the connection of a master process instead.
+type Tunnel struct {
+	Client *gossh.Client
+	Info   *openstack.Info
+	Path   string
+
+	ctx    context.Context
+	group  *errgroup.Group
+	master string
+}
+
+// reserved holds the ports handed out per hypervisor connection until their
+// netns-proxy exits, so tunnels sharing a connection don't pick the same port
+// before it has been bound.
+var reserved = struct {
+	sync.Mutex
+	clients map[*gossh.Client]*reservedPorts
+}{clients: map[*gossh.Client]*reservedPorts{}}
+
+type reservedPorts struct {
+	// lookup serializes the port lookups on the hypervisor, lookups on other
+	// hypervisors don't wait for it
+	lookup sync.Mutex
+	// ports and pending are guarded by reserved
+	ports   []int
+	pending int
+}
+
+func reservePort(c *gossh.Client, protocol string) (int, error) {
+	reserved.Lock()
+	r, ok := reserved.clients[c]
+	if !ok {
+		r = &reservedPorts{}
+		reserved.clients[c] = r
+	}
+	r.pending++
+	reserved.Unlock()
+
+	r.lookup.Lock()
+	defer r.lookup.Unlock()
+
+	reserved.Lock()
+	exclude := slices.Clone(r.ports)
+	reserved.Unlock()
+
+	port, err := netnsproxy.GetAvailablePort(c, protocol, exclude...)
+
+	reserved.Lock()
+	defer reserved.Unlock()
+	r.pending--
+	if err != nil {
+		forgetClient(c, r)
+		return 0, err
+	}
+	r.ports = append(r.ports, port)
+	return port, nil
+}
+
+func releasePort(c *gossh.Client, port int) {
+	reserved.Lock()
+	defer reserved.Unlock()
+
+	r, ok := reserved.clients[c]
+	if !ok {
+		return
+	}
+	r.ports = slices.DeleteFunc(r.ports, func(p int) bool { return p == port })
+	forgetClient(c, r)
+}
+
+// forgetClient drops the entry of the connection once it has no ports and no
+// lookups. reserved has to be locked.
+func forgetClient(c *gossh.Client, r *reservedPorts) {
+	if len(r.ports) == 0 && r.pending == 0 {
+		delete(reserved.clients, c)
+	}
+}
+
+// Open connects to the hypervisor of the server, looks up the network
+// namespace of its network and makes sure the netns-proxy is available.
+func Open(ctx context.Context, info *openstack.Info, username string) (*Tunnel, error) {
+	progress.Print("Connecting to SSH...")
+	c, err := ssh.NewClient(info.HypervisorHostname, username)
+	if err != nil {
+		progress.Println("Error")
+		return nil, err
+	}
+	progress.Println("Done")
+
+	err = netnsproxy.Setup(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	t, err := New(ctx, c, info)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// New sets up a tunnel for the server on an existing connection to its
+// hypervisor, on which netnsproxy.Setup has been run already. Several tunnels
+// may share a connection, closing one closes it for all of them.
+func New(ctx context.Context, c *gossh.Client, info *openstack.Info) (*Tunnel, error) {
+	path, err := utils.GetNetNSFromNeutronMetadata(c, info.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	return &Tunnel{
+		Client: c,
+		Info:   info,
+		Path:   path,
+		ctx:    ctx,
+		group:  group,
+	}, nil
+}
+
+// derive returns a tunnel on the connection and network namespace of t whose
+// netns-proxies are stopped when ctx is cancelled.
+func (t *Tunnel) derive(ctx context.Context) *Tunnel {
+	group, ctx := errgroup.WithContext(ctx)
+	return &Tunnel{
+		Client: t.Client,
+		Info:   t.Info,
+		Path:   t.Path,
+		ctx:    ctx,
+		group:  group,
+	}
+}
+
+// Forward starts a netns-proxy to the given port of the server and returns
+// the address it listens on at the hypervisor.
+func (t *Tunnel) Forward(protocol string, remotePort int) (generic.AddressPort, error) {
+	if t.master != "" {
+		progress.Print("Setting up remote port forwarding...")
+		conn, resp, err := masterCall(t.master, masterRequest{
+			Op:        "forward",
+			NetworkID: t.Info.NetworkID,
+			Address:   t.Info.IPAddress,
+			Protocol:  protocol,
+			Port:      remotePort,
+		})
+		if err != nil {
+			progress.Println("Error")
+			return generic.AddressPort{}, err
+		}
+		// the master keeps the netns-proxy while the connection is open
+		t.group.Go(func() error {
+			stop := context.AfterFunc(t.ctx, func() { conn.Close() })
+			defer stop()
+			io.Copy(io.Discard, conn)
+			if t.ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("master stopped forward to port %d", remotePort)
+		})
+		progress.Println("Done")
+		return generic.AddressPort{Address: "127.0.0.1", Port: resp.Port, Type: protocol}, nil
+	}
+
+	proxyPort, err := reservePort(t.Client, protocol)
+	if err != nil {
+		return generic.AddressPort{}, err
+	}
+
+	err = t.start(netnsproxy.NetnsProxyOpts{
+		ListenPort: proxyPort,
+		Address:    t.Info.IPAddress,
+		Path:       t.Path,
+		Protocol:   protocol,
+		ProxyPort:  remotePort,
+	})
+	if err != nil {
+		return generic.AddressPort{}, err
+	}
+
+	return generic.AddressPort{
+		Address: "127.0.0.1",
+		Port:    proxyPort,
+		Type:    protocol,
+	}, nil
+}
+
+// Reverse listens on bindAddress:remotePort inside the network namespace and
+// returns a listener for the connections made to it.
+func (t *Tunnel) Reverse(bindAddress string, remotePort int) (net.Listener, error) {
+	if t.master != "" {
+		progress.Print("Setting up remote port forwarding...")
+		ctrl, resp, err := masterCall(t.master, masterRequest{
+			Op:        "reverse",
+			NetworkID: t.Info.NetworkID,
+			Address:   bindAddress,
+			Port:      remotePort,
+		})
+		if err != nil {
+			progress.Println("Error")
+			return nil, err
+		}
+		progress.Println("Done")
+		return &masterListener{sock: t.master, id: resp.ID, ctrl: ctrl}, nil
+	}
+
+	listener, hvPort, err := ssh.ReverseListen(t.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.start(netnsproxy.NetnsProxyOpts{
+		ListenPort: remotePort,
+		Address:    bindAddress,
+		Path:       t.Path,
+		Protocol:   "tcp",
+		ProxyPort:  hvPort,
+		Reverse:    true,
+	})
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (t *Tunnel) start(opts netnsproxy.NetnsProxyOpts) error {
+	progress.Print("Setting up remote port forwarding...")
+	t.group.Go(func() error {
+		if !opts.Reverse {
+			defer releasePort(t.Client, opts.ListenPort)
+		}
+		return netnsproxy.RunNetnsProxy(t.ctx, t.Client, opts)
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-t.ctx.Done():
+		progress.Println("Error")
+		return fmt.Errorf("failed to setup port-forwarding")
+	default:
+		progress.Println("Done")
+	}
+	return nil
+}
+
+// Dial opens a connection to the server through a forward started with
+// Forward.
+func (t *Tunnel) Dial(addr generic.AddressPort) (net.Conn, error) {
+	if t.master != "" {
+		conn, _, err := masterCall(t.master, masterRequest{Op: "dial", Protocol: addr.Network(), Port: addr.Port})
+		return conn, err
+	}
+	return t.Client.Dial(addr.Network(), addr.String())
+}
+
+// UDPRelay returns the stream framed datagrams for the udp forward started
+// with Forward are exchanged over, see ssh.UDPForward.
+func (t *Tunnel) UDPRelay(addr generic.AddressPort) (io.ReadWriteCloser, error) {
+	if t.master != "" {
+		conn, _, err := masterCall(t.master, masterRequest{Op: "relay", Port: addr.Port})
+		return conn, err
+	}
+
+	cmd, err := netnsproxy.UDPRelayCommand(addr.Port)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.UDPRelay(t.Client, cmd)
+}
+
+// Done is closed once the tunnel fails or its context is cancelled.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.ctx.Done()
+}
+
+// Wait blocks until all netns-proxies have been shut down.
+func (t *Tunnel) Wait() error {
+	return t.group.Wait()
+}
+
+// Close closes the connection to the hypervisor. The connection of a master
+// is left open for other processes.
+func (t *Tunnel) Close() error {
+	if t.master != "" {
+		return nil
+	}
+	return t.Client.Close()
+}
@@ -1,8 +1,21 @@
 package generic
 
+import "time"
+
 type Args struct {
-	UUID       string
-	Username   string
-	Port       int
-	RemotePort int
+	Server      string
+	Username    string
+	Project     string
+	Cloud       string
+	AllClouds   bool
+	AllRegions  bool
+	AllProjects bool
+	Port        int
+	RemotePort  int
+	Forwards    []Forward
+	Reverse     []ReverseForward
+	Background  bool
+	Master      bool
+	Lazy        bool
+	IdleTimeout time.Duration
 }
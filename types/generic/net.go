@@ -1,6 +1,10 @@
 package generic
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
 
 type AddressPort struct {
 	Address string
@@ -15,3 +19,38 @@ func (a AddressPort) String() string {
 func (a AddressPort) Network() string {
 	return a.Type
 }
+
+// Forward describes a single local port that is forwarded to a port on the VM.
+type Forward struct {
+	Type string
+	// BindAddress is the local address to listen on, defaults to 127.0.0.1.
+	BindAddress string
+	LocalPort   int
+	RemotePort  int
+}
+
+// LocalAddress returns the local address the forward listens on.
+func (f Forward) LocalAddress() string {
+	bind := f.BindAddress
+	if bind == "" {
+		bind = "127.0.0.1"
+	}
+	return net.JoinHostPort(bind, strconv.Itoa(f.LocalPort))
+}
+
+func (f Forward) String() string {
+	return fmt.Sprintf("%s:%d:%d", f.Type, f.LocalPort, f.RemotePort)
+}
+
+// ReverseForward describes a port inside the tenant network that is forwarded
+// back to a local target.
+type ReverseForward struct {
+	BindAddress string
+	RemotePort  int
+	LocalHost   string
+	LocalPort   int
+}
+
+func (r ReverseForward) String() string {
+	return fmt.Sprintf("%s:%d:%s:%d", r.BindAddress, r.RemotePort, r.LocalHost, r.LocalPort)
+}
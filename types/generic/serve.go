@@ -0,0 +1,25 @@
+package generic
+
+// ServeConfig configures the SSH bastion started by osssh serve.
+type ServeConfig struct {
+	// Listen is the address the SSH server listens on.
+	Listen string `yaml:"listen" json:"listen"`
+	// HostKey is the private host key of the SSH server, it is created if
+	// it does not exist.
+	HostKey string `yaml:"host_key" json:"host_key"`
+	// HypervisorUser is the user osssh logs in to the hypervisors with,
+	// defaults to the user running osssh.
+	HypervisorUser string `yaml:"hypervisor_user,omitempty" json:"hypervisor_user,omitempty"`
+	// Users may connect through the bastion.
+	Users []ServeUser `yaml:"users" json:"users"`
+}
+
+// ServeUser maps the SSH keys of a user to a Keystone identity.
+type ServeUser struct {
+	Name string `yaml:"name" json:"name"`
+	// Keys are public keys in authorized_keys format.
+	Keys []string `yaml:"keys" json:"keys"`
+	// Cloud is the entry in clouds.yaml holding the credentials of the user,
+	// only servers visible to them can be reached.
+	Cloud string `yaml:"cloud" json:"cloud"`
+}
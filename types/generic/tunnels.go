@@ -0,0 +1,23 @@
+package generic
+
+// TunnelsFile lists the tunnels brought up by osssh up.
+type TunnelsFile struct {
+	Tunnels []TunnelSpec `yaml:"tunnels" json:"tunnels"`
+}
+
+// TunnelSpec describes a single forward to a server.
+type TunnelSpec struct {
+	// Server is the name or uuid of the server.
+	Server string `yaml:"server" json:"server"`
+	// Cloud is the entry in clouds.yaml, defaults to the cloud from the environment.
+	Cloud string `yaml:"cloud,omitempty" json:"cloud,omitempty"`
+	// Network is the name or uuid of the network to reach the server on,
+	// defaults to the network of its first port.
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+	// Local is the local [address:]port to listen on.
+	Local string `yaml:"local" json:"local"`
+	// Remote is the port on the server.
+	Remote int `yaml:"remote" json:"remote"`
+	// Protocol is either tcp or udp, defaults to tcp.
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+}
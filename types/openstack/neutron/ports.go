@@ -14,8 +14,17 @@ type Port struct {
 	// Identifies the device (e.g., virtual server) using this port.
 	DeviceID string `json:"device_id"`
 
+	// Identifies the entity using this port, compute:<zone> for servers.
+	DeviceOwner string `json:"device_owner"`
+
 	// Show the Binding Hypervisor
 	HostID string `json:"binding:host_id"`
+
+	// Security groups applied to the port.
+	SecurityGroups []string `json:"security_groups"`
+
+	// Whether security groups and anti-spoofing are enforced on the port.
+	PortSecurityEnabled *bool `json:"port_security_enabled"`
 }
 
 // IP is a sub-struct that represents an individual IP.
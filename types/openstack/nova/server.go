@@ -4,4 +4,7 @@ type Server struct {
 	ID                 string `json:"id"`
 	Name               string `json:"name"`
 	HypervisorHostname string `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
+	KeyName            string `json:"key_name"`
+	TenantID           string `json:"tenant_id"`
+	UserID             string `json:"user_id"`
 }